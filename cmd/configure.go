@@ -1,29 +1,100 @@
 package cmd
 
 import (
+	"errors"
+
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
 )
 
 func init() {
 	rootCmd.AddCommand(configureCmd)
+	configureCmd.Flags().StringVar(&configureSettings.KeyHubUrl, "keyhub-url", "", "KeyHub url (e.g. https://keyhub.domain.tld)")
+	configureCmd.Flags().StringVar(&configureSettings.KeyHubClientId, "client-id", "", "KeyHub aws-keyhub client id")
+	configureCmd.Flags().StringVar(&configureSettings.KeyHubAwsSamlClientId, "aws-saml-client-id", "", "KeyHub Resource URN for the AWS SAML connection (e.g. urn:tkh-clientid:urn:amazon:webservices)")
+	configureCmd.Flags().Int32Var(&configureSettings.AssumeDuration, "assume-duration", 43200, "AWS assume role duration in seconds, maximum value is 43200")
+	configureCmd.Flags().StringVar(&configureSettings.GrantType, "grant-type", "", "KeyHub login method: device_code or authorization_code (default device_code)")
+	configureCmd.Flags().StringVar(&configureSettings.SecretStore, "secret-store", "", "where to store the KeyHub refresh token: file, keyring or encrypted-file (default file)")
+	configureCmd.Flags().BoolVar(&configureSettings.EncryptCredentials, "encrypt-credentials", false, "keep AWS sessions encrypted instead of writing them to ~/.aws/credentials")
+	configureCmd.Flags().StringVar(&configureSettings.ProfileTemplate, "profile-template", "", "AWS profile name template (e.g. keyhub-{{.AccountId}}-{{.RoleName}})")
 }
 
 var configureCmd = &cobra.Command{
 	Use:   "configure",
 	Short: "configure settings",
-	Long:  `Configure the settings for aws-keyhub`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Configure the settings for aws-keyhub. Without flags a wizard asks for the settings, with flags or
+without a terminal the settings are taken from the flags. The KeyHub url, client id and AWS SAML client id are
+required then, unless the context is already configured: settings without a flag are kept.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if Verbose {
 			logrus.SetLevel(logrus.DebugLevel)
 		}
-		configure()
+		return configure(cmd)
 	},
 }
 
-func configure() {
-	aws_keyhub.AssureAwsKeyHubConfigDirectoryExists()
-	aws_keyhub.ConfigureAwsKeyhub()
+var configureSettings aws_keyhub.ConfigureSettings
+
+func configure(cmd *cobra.Command) error {
+	if err := aws_keyhub.AssureAwsKeyHubConfigDirectoryExists(); err != nil {
+		return err
+	}
+	if !hasSettingFlags(cmd) && !isNonInteractive() {
+		if err := aws_keyhub.ConfigureAwsKeyhub(); err != nil {
+			return err
+		}
+	} else if err := aws_keyhub.ConfigureAwsKeyhubWithSettings(settingsFromFlags(cmd)); err != nil {
+		return err
+	}
+	if context := aws_keyhub.GetContext(); context != aws_keyhub.DefaultContext {
+		logrus.Infof("Configuration of aws-keyhub context `%[1]s` completed. You can now use the `login --context %[1]s` command, or make it the current context with `context use %[1]s`.", context)
+		return nil
+	}
 	logrus.Infoln("Configuration of aws-keyhub completed. You can now use the `login` command.")
+	return nil
+}
+
+// hasSettingFlags reports whether one of the settings is set with a flag.
+func hasSettingFlags(cmd *cobra.Command) bool {
+	changed := false
+	cmd.LocalNonPersistentFlags().VisitAll(func(flag *pflag.Flag) {
+		changed = changed || flag.Changed
+	})
+	return changed
+}
+
+// settingsFromFlags returns the settings of the flags, the settings of the current configuration are kept for the
+// flags that are not set.
+func settingsFromFlags(cmd *cobra.Command) aws_keyhub.ConfigureSettings {
+	settings := configureSettings
+	config, err := aws_keyhub.LoadAwsKeyHubConfig()
+	var configNotFoundError *aws_keyhub.ConfigNotFoundError
+	if err != nil {
+		if !errors.As(err, &configNotFoundError) {
+			logrus.Warnln("Ignoring the current configuration, it could not be read.", err)
+		}
+		return settings
+	}
+	flags := cmd.Flags()
+	keep := func(flag string, setting *string, current string) {
+		if !flags.Changed(flag) {
+			*setting = current
+		}
+	}
+	keep("keyhub-url", &settings.KeyHubUrl, config.Keyhub.Url)
+	keep("client-id", &settings.KeyHubClientId, config.Keyhub.ClientId)
+	keep("aws-saml-client-id", &settings.KeyHubAwsSamlClientId, config.Keyhub.AwsSamlClientId)
+	keep("grant-type", &settings.GrantType, config.Keyhub.GrantType)
+	keep("secret-store", &settings.SecretStore, config.Keyhub.SecretStore)
+	keep("profile-template", &settings.ProfileTemplate, config.Aws.ProfileTemplate)
+	if !flags.Changed("assume-duration") && config.Aws.AssumeDuration > 0 {
+		settings.AssumeDuration = config.Aws.AssumeDuration
+	}
+	if !flags.Changed("encrypt-credentials") {
+		settings.EncryptCredentials = config.Aws.EncryptCredentials
+	}
+	return settings
 }
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func init() {
+	rootCmd.AddCommand(contextCmd)
+	contextCmd.AddCommand(contextUseCmd)
+	contextCmd.AddCommand(contextListCmd)
+	contextCmd.AddCommand(contextDeleteCmd)
+}
+
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "manage KeyHub contexts",
+	Long: `Manage the aws-keyhub contexts. Every context holds its own KeyHub configuration and refresh token,
+which allows switching between for example a production and an acceptance KeyHub. A context is created
+with ` + "`aws-keyhub configure --context <name>`" + `.`,
+}
+
+var contextUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "switch the current context",
+	Long:  `Switch the context that is used when no --context flag is given`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		if err := aws_keyhub.UseContext(args[0]); err != nil {
+			return err
+		}
+		logrus.Infof("Switched to context `%s`.", args[0])
+		return nil
+	},
+}
+
+var contextListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the contexts",
+	Long:  `List the configured contexts, the current context is marked with an asterisk`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		contexts, err := aws_keyhub.ListContexts()
+		if err != nil {
+			return err
+		}
+		current := aws_keyhub.GetContext()
+		for _, name := range contexts {
+			marker := " "
+			if name == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+		return nil
+	},
+}
+
+var contextDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "delete a context",
+	Long:  `Delete the configuration, refresh token and cached credentials of a context`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		if err := aws_keyhub.DeleteContext(args[0]); err != nil {
+			return err
+		}
+		logrus.Infof("Deleted context `%s`.", args[0])
+		return nil
+	},
+}
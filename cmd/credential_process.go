@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func init() {
+	rootCmd.AddCommand(credentialProcessCmd)
+	credentialProcessCmd.Flags().StringVarP(&credentialProcessRoleArn, "role-arn", "r", "", "role ARN to retrieve credentials for")
+	credentialProcessCmd.MarkFlagRequired("role-arn")
+	credentialProcessCmd.Flags().BoolVarP(&force, "force", "f", false, "always login, even if cached credentials are still valid")
+}
+
+var credentialProcessCmd = &cobra.Command{
+	Use:   "credential-process",
+	Short: "print credentials for the AWS credential_process setting",
+	Long: `Retrieves AWS credentials and prints them in the format expected by the credential_process
+setting of the AWS CLI and SDKs. Add the following to a profile in ~/.aws/config to let the AWS
+tooling call aws-keyhub whenever it needs (new) credentials:
+
+    credential_process = aws-keyhub credential-process --role-arn arn:aws:iam::123456789012:role/MyCustomRole`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return credentialProcess(cmd.Context())
+	},
+}
+
+var credentialProcessRoleArn string
+
+func credentialProcess(ctx context.Context) error {
+	// The AWS CLI and SDKs run credential-process without a terminal to prompt on. Unlike the other commands, it still
+	// asks the user to authorize aws-keyhub when the refresh token expired, the AWS tooling would otherwise only fail
+	// until the user runs login. Only an explicit --non-interactive prevents that.
+	options := []aws_keyhub.ClientOption{aws_keyhub.WithoutPrompts()}
+	if nonInteractive {
+		options = append(options, aws_keyhub.WithoutAuthorization())
+	}
+	client, err := newClient(options...)
+	if err != nil {
+		return err
+	}
+	_, credentials, err := retrieveCredentials(ctx, client, credentialProcessRoleArn, true)
+	if err != nil {
+		return err
+	}
+	return aws_keyhub.WriteCredentialProcessOutput(os.Stdout, credentials)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+var roleAccount string
+var roleName string
+var rolePattern string
+var roleFilter string
+
+// addRoleSelectorFlags adds the flags that select a role without its ARN to a command that prompts for a role.
+func addRoleSelectorFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&roleAccount, "account", "", "select the role in the account with this ID or alias")
+	cmd.Flags().StringVar(&roleName, "role-name", "", "select the role with this name, ignoring case")
+	cmd.Flags().StringVar(&rolePattern, "role", "", "select the role whose ARN, name, description or account alias matches this pattern, * and ? are wildcards")
+	cmd.Flags().StringVar(&roleFilter, "filter", "", "only show the roles matching the filter when asking for the role, the only matching role is selected without asking")
+}
+
+// roleSelector returns the role selector of the role ARNs and the flags added by addRoleSelectorFlags.
+func roleSelector(roleArns ...string) aws_keyhub.RoleSelector {
+	return aws_keyhub.RoleSelector{
+		RoleArns: slices.DeleteFunc(slices.Clone(roleArns), func(roleArn string) bool { return roleArn == "" }),
+		Account:  roleAccount,
+		RoleName: roleName,
+		Role:     rolePattern,
+		Filter:   roleFilter,
+	}
+}
+
+// retrieveCredentials returns the credentials for the role without writing them to a profile, from the cache when
+// possible. The user is prompted for a role when the role ARN and role selector flags do not select a single role.
+// New credentials are only cached when storeInCache is set.
+func retrieveCredentials(ctx context.Context, client *aws_keyhub.Client, roleArn string, storeInCache bool) (aws_keyhub.RolesAndPrincipals, *types.Credentials, error) {
+	selector := roleSelector(roleArn)
+	if !force && len(roleArn) > 0 {
+		cachedCredentials, err := client.GetCachedCredentialsForRole(roleArn)
+		if err != nil {
+			return aws_keyhub.RolesAndPrincipals{}, nil, err
+		}
+		if cachedCredentials != nil {
+			return cachedCredentials.RolesAndPrincipals(), &cachedCredentials.Credentials, nil
+		}
+	}
+
+	samlAssertion, err := client.RetrieveSamlAssertion(ctx)
+	if err != nil {
+		return aws_keyhub.RolesAndPrincipals{}, nil, err
+	}
+
+	selectedRoleAndPrincipal, err := client.SelectRoleAndPrincipal(selector, samlAssertion.RolesAndPrincipals)
+	if err != nil {
+		return aws_keyhub.RolesAndPrincipals{}, nil, err
+	}
+	if !force && len(roleArn) == 0 {
+		cachedCredentials, err := client.GetCachedCredentialsForRole(selectedRoleAndPrincipal.Role)
+		if err != nil {
+			return aws_keyhub.RolesAndPrincipals{}, nil, err
+		}
+		if cachedCredentials != nil {
+			return cachedCredentials.RolesAndPrincipals(), &cachedCredentials.Credentials, nil
+		}
+	}
+	samlOutput, err := client.StsAssumeRoleWithSAML(ctx, selectedRoleAndPrincipal.Principal, selectedRoleAndPrincipal.Role, samlAssertion.Assertion)
+	if err != nil {
+		return aws_keyhub.RolesAndPrincipals{}, nil, err
+	}
+
+	if storeInCache {
+		if err := client.StoreCachedCredentials(selectedRoleAndPrincipal, "", samlOutput.Credentials); err != nil {
+			return aws_keyhub.RolesAndPrincipals{}, nil, err
+		}
+	}
+	return selectedRoleAndPrincipal, samlOutput.Credentials, nil
+}
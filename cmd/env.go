@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+	envCmd.Flags().StringVarP(&envRoleArn, "role-arn", "r", "", "login with the specified role ARN instead of asking for the role you want to login with")
+	envCmd.Flags().BoolVarP(&force, "force", "f", false, "always login, even if cached credentials are still valid")
+	addEnvFlags(envCmd)
+	addRoleSelectorFlags(envCmd)
+}
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "print credentials as environment variables",
+	Long: `Retrieves AWS credentials and prints them as environment variables for your shell, without writing
+them to ~/.aws/credentials. For example:
+
+    eval "$(aws-keyhub env --role-arn arn:aws:iam::123456789012:role/MyCustomRole)"`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return env(cmd.Context())
+	},
+}
+
+var envRoleArn string
+var envShell string
+var envRegion string
+
+func addEnvFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&envShell, "shell", "", "shell syntax of the environment variables ("+strings.Join(aws_keyhub.Shells, ", ")+"), detected when omitted")
+	cmd.Flags().StringVar(&envRegion, "region", "", "also set AWS_REGION and AWS_DEFAULT_REGION")
+}
+
+func env(ctx context.Context) error {
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	_, credentials, err := retrieveCredentials(ctx, client, envRoleArn, true)
+	if err != nil {
+		return err
+	}
+	return writeEnvironmentVariables(credentials)
+}
+
+func writeEnvironmentVariables(credentials *types.Credentials) error {
+	shell := envShell
+	if shell == "" {
+		shell = aws_keyhub.DetectShell()
+		logrus.Debugln("Detected shell:", shell)
+	}
+	return aws_keyhub.WriteEnvironmentVariables(os.Stdout, shell, credentials, envRegion)
+}
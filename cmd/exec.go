@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+	execCmd.Flags().StringVarP(&execRoleArn, "role-arn", "r", "", "login with the specified role ARN instead of asking for the role you want to login with")
+	execCmd.Flags().StringVar(&execRegion, "region", "", "set AWS_REGION and AWS_DEFAULT_REGION for the command")
+	execCmd.Flags().BoolVarP(&force, "force", "f", false, "always login, even if cached credentials are still valid")
+	addRoleSelectorFlags(execCmd)
+	execCmd.Flags().SetInterspersed(false)
+}
+
+var execCmd = &cobra.Command{
+	Use:   "exec [flags] -- command [args...]",
+	Short: "run a command with AWS credentials",
+	Long: `Retrieves AWS credentials and runs the command with the credentials in its environment. The
+credentials are not written to disk. For example:
+
+    aws-keyhub exec --role-arn arn:aws:iam::123456789012:role/MyCustomRole -- terraform plan`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		exitCode, err := runWithCredentials(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		os.Exit(exitCode)
+		return nil
+	},
+}
+
+var execRoleArn string
+var execRegion string
+
+// runWithCredentials runs the command with the credentials of the role and returns its exit code.
+func runWithCredentials(ctx context.Context, args []string) (int, error) {
+	client, err := loadClient()
+	if err != nil {
+		return 0, err
+	}
+	roleAndPrincipal, credentials, err := retrieveCredentials(ctx, client, execRoleArn, false)
+	if err != nil {
+		return 0, err
+	}
+
+	command := exec.Command(args[0], args[1:]...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	command.Env = commandEnvironment(aws_keyhub.CredentialEnvironmentVariables(credentials, execRegion))
+
+	// aws-keyhub keeps running until the command stops. Signals from the terminal (Ctrl-C) already reach the command
+	// through its process group, other signals are forwarded to the command.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	logrus.Debugf("Running %s with credentials for role %s", args[0], roleAndPrincipal.Role)
+	if err := command.Start(); err != nil {
+		return 0, fmt.Errorf("failed to run %s: %w", args[0], err)
+	}
+	go func() {
+		for sig := range signals {
+			if sig == os.Interrupt || sig == syscall.SIGQUIT {
+				continue
+			}
+			if err := command.Process.Signal(sig); err != nil {
+				logrus.Debugln("Failed to forward signal to command:", err)
+			}
+		}
+	}()
+
+	err = command.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to run %s: %w", args[0], err)
+	}
+	return 0, nil
+}
+
+// commandEnvironment returns the environment of aws-keyhub with the AWS variables replaced. The profile variables are
+// removed, so tools do not prefer a profile over the credentials.
+func commandEnvironment(variables [][2]string) []string {
+	replaced := []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE"}
+	for _, variable := range variables {
+		replaced = append(replaced, variable[0])
+	}
+
+	var environment []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if !slices.Contains(replaced, name) {
+			environment = append(environment, entry)
+		}
+	}
+	for _, variable := range variables {
+		environment = append(environment, variable[0]+"="+variable[1])
+	}
+	return environment
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+// TestExecHelperProcess is the command run by the exec tests. It writes its AWS environment variables to the file in
+// EXEC_HELPER_OUTPUT and exits with the exit code in its argument.
+func TestExecHelperProcess(t *testing.T) {
+	outputPath := os.Getenv("EXEC_HELPER_OUTPUT")
+	if outputPath == "" {
+		return
+	}
+	var output strings.Builder
+	for _, entry := range os.Environ() {
+		if strings.HasPrefix(entry, "AWS_") {
+			fmt.Fprintln(&output, entry)
+		}
+	}
+	if err := os.WriteFile(outputPath, []byte(output.String()), 0600); err != nil {
+		os.Exit(100)
+	}
+	exitCode, _ := strconv.Atoi(os.Args[len(os.Args)-1])
+	os.Exit(exitCode)
+}
+
+// authorize sets up the fakes with a stored refresh token, so aws-keyhub does not wait for the user to authorize it.
+func authorize(t *testing.T) {
+	t.Helper()
+	keyhub, _ := setupFakes(t, testRoles...)
+	refreshToken, err := json.Marshal(aws_keyhub.RefreshTokenFile{RefreshToken: keyhub.IssueRefreshToken(), ExpireDate: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(os.Getenv("HOME"), ".aws-keyhub", "refresh-token.json"), refreshToken, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runExecHelper runs the helper process with exec and returns its exit code and AWS environment variables.
+func runExecHelper(t *testing.T, exitCode int) (int, map[string]string) {
+	t.Helper()
+	outputPath := filepath.Join(t.TempDir(), "environment")
+	t.Setenv("EXEC_HELPER_OUTPUT", outputPath)
+	execRoleArn = testRoles[0].RoleArn
+	t.Cleanup(func() { execRoleArn = "" })
+
+	got, err := runWithCredentials(context.Background(), []string{os.Args[0], "-test.run=^TestExecHelperProcess$", "--", strconv.Itoa(exitCode)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	environment := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name, value, _ := strings.Cut(line, "=")
+		environment[name] = value
+	}
+	return got, environment
+}
+
+func TestExec(t *testing.T) {
+	t.Run("credentials", func(t *testing.T) {
+		authorize(t)
+
+		_, environment := runExecHelper(t, 0)
+		accessKeyId := environment["AWS_ACCESS_KEY_ID"]
+		if accessKeyId == "" || environment["AWS_SECRET_ACCESS_KEY"] != "secret-"+accessKeyId || environment["AWS_SESSION_TOKEN"] != "token-"+accessKeyId {
+			t.Errorf("command did not get the credentials of the role: %v", environment)
+		}
+		if environment["AWS_CREDENTIAL_EXPIRATION"] == "" {
+			t.Errorf("command did not get the expiration of the credentials: %v", environment)
+		}
+	})
+
+	t.Run("conflicting variables", func(t *testing.T) {
+		authorize(t)
+		t.Setenv("AWS_PROFILE", "other")
+		t.Setenv("AWS_DEFAULT_PROFILE", "other")
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKIAPARENT")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "parent-secret")
+		t.Setenv("AWS_SESSION_TOKEN", "parent-token")
+
+		_, environment := runExecHelper(t, 0)
+		for _, name := range []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE"} {
+			if value, ok := environment[name]; ok {
+				t.Errorf("command got %s=%s from aws-keyhub", name, value)
+			}
+		}
+		if accessKeyId := environment["AWS_ACCESS_KEY_ID"]; accessKeyId == "AKIAPARENT" || environment["AWS_SECRET_ACCESS_KEY"] != "secret-"+accessKeyId || environment["AWS_SESSION_TOKEN"] != "token-"+accessKeyId {
+			t.Errorf("command got the credentials of the parent instead of the role: %v", environment)
+		}
+	})
+
+	t.Run("exit code", func(t *testing.T) {
+		authorize(t)
+
+		if exitCode, _ := runExecHelper(t, 42); exitCode != 42 {
+			t.Errorf("exit code %d, want the exit code of the command 42", exitCode)
+		}
+	})
+}
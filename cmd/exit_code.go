@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+// The exit codes of aws-keyhub, so scripts can tell why it failed without parsing the error message.
+const (
+	ExitOK                    = 0
+	ExitError                 = 1 // Any other error.
+	ExitConfigMissing         = 3 // The aws-keyhub or AWS CLI configuration does not exist.
+	ExitAuthorizationRequired = 4 // The user has to authorize aws-keyhub (again), or denied the authorization.
+	ExitRoleNotFound          = 5 // The selected role is not available.
+	ExitRoleRequired          = 6 // No role or more than one role was selected, and aws-keyhub cannot prompt for it.
+	ExitStsFailed             = 7 // AWS STS refused or failed to hand out the credentials.
+)
+
+// ExitCode returns the exit code for the error returned by Execute.
+func ExitCode(err error) int {
+	var configNotFoundError *aws_keyhub.ConfigNotFoundError
+	var awsConfigNotFoundError *aws_keyhub.AwsConfigNotFoundError
+	var roleNotFoundError *aws_keyhub.RoleNotFoundError
+	var noMatchingRoleError *aws_keyhub.NoMatchingRoleError
+	var ambiguousRoleError *aws_keyhub.AmbiguousRoleError
+	var stsError *aws_keyhub.StsError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &configNotFoundError), errors.As(err, &awsConfigNotFoundError):
+		return ExitConfigMissing
+	case errors.Is(err, aws_keyhub.ErrAuthorizationRequired), errors.Is(err, aws_keyhub.ErrAuthorizationDenied), errors.Is(err, aws_keyhub.ErrAuthorizationTimeout):
+		return ExitAuthorizationRequired
+	case errors.As(err, &roleNotFoundError), errors.As(err, &noMatchingRoleError):
+		return ExitRoleNotFound
+	case errors.Is(err, aws_keyhub.ErrRoleRequired), errors.As(err, &ambiguousRoleError):
+		return ExitRoleRequired
+	case errors.As(err, &stsError):
+		return ExitStsFailed
+	}
+	return ExitError
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"testing/synctest"
+
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitOK},
+		{errors.New("failed"), ExitError},
+		{&aws_keyhub.ConfigNotFoundError{Context: aws_keyhub.DefaultContext}, ExitConfigMissing},
+		{fmt.Errorf("login failed: %w", aws_keyhub.ErrAuthorizationRequired), ExitAuthorizationRequired},
+		{aws_keyhub.ErrAuthorizationDenied, ExitAuthorizationRequired},
+		{&aws_keyhub.RoleNotFoundError{RoleArn: testRoles[0].RoleArn}, ExitRoleNotFound},
+		{&aws_keyhub.NoMatchingRoleError{Selector: aws_keyhub.RoleSelector{RoleName: "Nope"}}, ExitRoleNotFound},
+		{aws_keyhub.ErrRoleRequired, ExitRoleRequired},
+		{&aws_keyhub.AmbiguousRoleError{Selector: aws_keyhub.RoleSelector{Role: "*"}}, ExitRoleRequired},
+		{&aws_keyhub.StsError{Operation: "AssumeRoleWithSAML", Err: errors.New("AccessDenied")}, ExitStsFailed},
+	}
+	for _, test := range tests {
+		if got := ExitCode(test.err); got != test.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+func TestNonInteractive(t *testing.T) {
+	t.Run("configuration missing", func(t *testing.T) {
+		setupFakes(t, testRoles...)
+		keyhubtest.SetupHome(t)
+
+		if err := runCommand(t, "login", "--non-interactive", "--role-arn", testRoles[0].RoleArn); ExitCode(err) != ExitConfigMissing {
+			t.Errorf("expected exit code %d, got %d: %v", ExitConfigMissing, ExitCode(err), err)
+		}
+	})
+
+	t.Run("authorization required", func(t *testing.T) {
+		keyhub, _ := setupFakes(t, testRoles...)
+
+		err := runCommand(t, "login", "--non-interactive", "--role-arn", testRoles[0].RoleArn)
+		if ExitCode(err) != ExitAuthorizationRequired {
+			t.Errorf("expected exit code %d, got %d: %v", ExitAuthorizationRequired, ExitCode(err), err)
+		}
+		if requests := keyhub.Requests("authorizedevice"); requests != 0 {
+			t.Errorf("asked the user to authorize aws-keyhub %d times", requests)
+		}
+	})
+
+	t.Run("without a terminal", func(t *testing.T) {
+		keyhub, _ := setupFakes(t, testRoles...)
+		isTerminal = func(file *os.File) bool { return false }
+
+		err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn)
+		if ExitCode(err) != ExitAuthorizationRequired {
+			t.Errorf("expected exit code %d, got %d: %v", ExitAuthorizationRequired, ExitCode(err), err)
+		}
+		if requests := keyhub.Requests("authorizedevice"); requests != 0 {
+			t.Errorf("asked the user to authorize aws-keyhub %d times", requests)
+		}
+	})
+
+	t.Run("credential-process", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			keyhub, _ := setupFakes(t, testRoles...)
+			isTerminal = func(file *os.File) bool { return false }
+			discardStdout(t)
+
+			if err := runCommand(t, "credential-process", "--non-interactive", "--role-arn", testRoles[0].RoleArn); ExitCode(err) != ExitAuthorizationRequired {
+				t.Errorf("expected exit code %d, got %d: %v", ExitAuthorizationRequired, ExitCode(err), err)
+			}
+			// The AWS tooling never runs credential-process on a terminal, it still asks to authorize aws-keyhub.
+			if err := runCommand(t, "credential-process", "--role-arn", testRoles[0].RoleArn); err != nil {
+				t.Fatal(err)
+			}
+			if requests := keyhub.Requests("authorizedevice"); requests != 1 {
+				t.Errorf("asked the user to authorize aws-keyhub %d times, want 1", requests)
+			}
+		})
+	})
+
+	t.Run("role", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			setupFakes(t, testRoles...)
+			if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn); err != nil {
+				t.Fatal(err)
+			}
+			// Without a terminal aws-keyhub does not prompt, even without --non-interactive.
+			isTerminal = func(file *os.File) bool { return false }
+
+			if err := runCommand(t, "login", "--force"); ExitCode(err) != ExitRoleRequired {
+				t.Errorf("expected exit code %d, got %d: %v", ExitRoleRequired, ExitCode(err), err)
+			}
+			if err := runCommand(t, "login", "--force", "--role-name", "Nope"); ExitCode(err) != ExitRoleNotFound {
+				t.Errorf("expected exit code %d, got %d: %v", ExitRoleNotFound, ExitCode(err), err)
+			}
+			if err := runCommand(t, "login", "--force", "--role-name", "readonly"); err != nil {
+				t.Errorf("selecting the role by name failed: %v", err)
+			}
+		})
+	})
+
+	t.Run("configure", func(t *testing.T) {
+		setupFakes(t, testRoles...)
+		keyhubtest.SetupHome(t)
+
+		if err := runCommand(t, "configure", "--non-interactive"); ExitCode(err) != ExitConfigMissing {
+			t.Errorf("expected exit code %d without settings, got %d: %v", ExitConfigMissing, ExitCode(err), err)
+		}
+		if err := runCommand(t, "configure", "--non-interactive", "--keyhub-url", "https://keyhub.test"); ExitCode(err) != ExitConfigMissing {
+			t.Errorf("expected exit code %d without client ids, got %d: %v", ExitConfigMissing, ExitCode(err), err)
+		}
+		err := runCommand(t, "configure", "--non-interactive", "--keyhub-url", "https://keyhub.test", "--client-id", keyhubtest.ClientId, "--aws-saml-client-id", keyhubtest.AwsSamlClientId, "--secret-store", aws_keyhub.SecretStoreKeyring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Settings without a flag are kept when reconfiguring.
+		if err := runCommand(t, "configure", "--assume-duration", "3600"); err != nil {
+			t.Fatal(err)
+		}
+		config, err := aws_keyhub.LoadAwsKeyHubConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Keyhub.Url != "https://keyhub.test" || config.Keyhub.ClientId != keyhubtest.ClientId || config.Keyhub.SecretStore != aws_keyhub.SecretStoreKeyring || config.Aws.AssumeDuration != 3600 {
+			t.Errorf("unexpected configuration %+v", config)
+		}
+	})
+}
+
+// discardStdout discards what the command writes to os.Stdout instead of cmd.OutOrStdout, like credential-process.
+func discardStdout(t *testing.T) {
+	t.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
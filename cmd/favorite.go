@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(favoriteCmd)
+	favoriteCmd.AddCommand(favoriteAddCmd)
+	favoriteCmd.AddCommand(favoriteRemoveCmd)
+	favoriteCmd.AddCommand(favoriteListCmd)
+}
+
+var favoriteCmd = &cobra.Command{
+	Use:   "favorite",
+	Short: "manage favorite roles",
+	Long: `Manage the favorite roles of the context. Favorite roles are pinned at the top of the role picker,
+followed by the recently used roles.`,
+}
+
+var favoriteAddCmd = &cobra.Command{
+	Use:   "add <role-arn>...",
+	Short: "pin roles at the top of the role picker",
+	Long:  `Pin the roles with the role ARNs at the top of the role picker`,
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		client, err := loadClient()
+		if err != nil {
+			return err
+		}
+		for _, roleArn := range args {
+			if err := client.AddFavoriteRole(roleArn); err != nil {
+				return err
+			}
+			logrus.Infof("Added role %s to the favorites.", roleArn)
+		}
+		return nil
+	},
+}
+
+var favoriteRemoveCmd = &cobra.Command{
+	Use:   "remove <role-arn>...",
+	Short: "unpin roles",
+	Long:  `Remove the roles with the role ARNs from the favorites`,
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		client, err := loadClient()
+		if err != nil {
+			return err
+		}
+		for _, roleArn := range args {
+			removed, err := client.RemoveFavoriteRole(roleArn)
+			if err != nil {
+				return err
+			}
+			if removed {
+				logrus.Infof("Removed role %s from the favorites.", roleArn)
+			} else {
+				logrus.Warnf("Role %s is not a favorite.", roleArn)
+			}
+		}
+		return nil
+	},
+}
+
+var favoriteListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the favorite roles",
+	Long:  `List the role ARNs of the favorite roles`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		client, err := loadClient()
+		if err != nil {
+			return err
+		}
+		for _, roleArn := range client.Config.Aws.FavoriteRoles {
+			fmt.Fprintln(cmd.OutOrStdout(), roleArn)
+		}
+		return nil
+	},
+}
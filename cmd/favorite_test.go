@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestFavorite(t *testing.T) {
+	setupFakes(t, testRoles...)
+
+	if err := runCommand(t, "favorite", "add", testRoles[1].RoleArn, testRoles[0].RoleArn); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand(t, "favorite", "remove", testRoles[1].RoleArn); err != nil {
+		t.Fatal(err)
+	}
+	output, err := runCommandOutput(t, "favorite", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := testRoles[0].RoleArn + "\n"; output != expected {
+		t.Errorf("favorites %q, want %q", output, expected)
+	}
+}
@@ -2,8 +2,12 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -12,42 +16,354 @@ import (
 
 func init() {
 	rootCmd.AddCommand(loginCmd)
-	loginCmd.Flags().StringVarP(&roleArn, "role-arn", "r", "", "login with the specified role ARN instead of asking for the role you want to login with")
-	loginCmd.Flags().StringVarP(&profile, "profile", "p", "keyhub", "aws profile to write the credentials to")
+	loginCmd.Flags().StringArrayVarP(&roleArns, "role-arn", "r", nil, "login with the specified role ARN instead of asking for the role you want to login with, repeat to login with multiple roles")
+	loginCmd.Flags().StringVarP(&profile, "profile", "p", "", "aws profile to write the credentials to, when omitted the profile is determined by the profile template (default \"keyhub\")")
+	loginCmd.Flags().BoolVarP(&force, "force", "f", false, "always login, even if cached credentials are still valid")
+	loginCmd.Flags().BoolVarP(&multiple, "multiple", "m", false, "choose multiple roles to login with, every role is written to its own profile")
+	loginCmd.Flags().BoolVarP(&allRoles, "all", "a", false, "login with all available roles, every role is written to its own profile")
+	loginCmd.Flags().StringVar(&profileTemplate, "profile-template", "", "template for the profile names when logging in with multiple roles, available fields: .AccountId, .RoleName, .RoleArn, .PrincipalArn and .Description (default \""+aws_keyhub.DefaultProfileTemplate+"\")")
+	addEnvFlags(loginCmd)
+	addRoleSelectorFlags(loginCmd)
+	loginCmd.MarkFlagsMutuallyExclusive("all", "role-arn")
 }
 
 var loginCmd = &cobra.Command{
 	Use:   "login",
 	Short: "login to AWS",
-	Long:  `Starts the login flow to retrieve AWS credentials`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Starts the login flow to retrieve AWS credentials. With --output json or yaml the roles, profiles,
+session expirations and caller identities are written to stdout, with --output env the credentials are printed
+as environment variables instead of written to a profile.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if Verbose {
 			logrus.SetLevel(logrus.DebugLevel)
 		}
-		login()
+		return login(cmd)
 	},
 }
 
-var roleArn string
+var roleArns []string
 var profile string
+var force bool
+var multiple bool
+var allRoles bool
+var profileTemplate string
 
-func login() {
-	aws_keyhub.CheckIfAwsKeyHubConfigFileExists()
-	aws_keyhub.CheckIfAwsConfigFileExists()
-	ctx := context.Background()
+// LoginOutputEnv is the format login supports as --output in addition to text, json and yaml: print the credentials
+// as environment variables instead of writing a profile.
+const LoginOutputEnv = "env"
 
-	loginResponse := aws_keyhub.DoLogin()
+// LoginReport is the document login writes with --output json or yaml.
+type LoginReport struct {
+	Context string        `json:"context"`
+	Logins  []LoginResult `json:"logins"`
+}
+
+// LoginResult is a role login wrote to a profile.
+type LoginResult struct {
+	Profile      string    `json:"profile"`
+	RoleArn      string    `json:"roleArn"`
+	PrincipalArn string    `json:"principalArn"`
+	Description  string    `json:"description,omitempty"`
+	Expiration   time.Time `json:"expiration"`
+	// Cached is set when the session was reused from the credential cache instead of assumed with STS.
+	Cached bool `json:"cached"`
+	// CallerIdentity is the identity of the session according to STS GetCallerIdentity, when the profile was verified.
+	CallerIdentity *CallerIdentity `json:"callerIdentity,omitempty"`
+}
+
+// CallerIdentity is the result of STS GetCallerIdentity.
+type CallerIdentity struct {
+	Account string `json:"account"`
+	Arn     string `json:"arn"`
+	UserId  string `json:"userId"`
+}
+
+func login(cmd *cobra.Command) error {
+	if err := checkOutputFormat(LoginOutputEnv); err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	var roleArn string
+	if len(roleArns) == 1 {
+		roleArn = roleArns[0]
+	}
+
+	if outputFormat == LoginOutputEnv {
+		if multiple || allRoles || len(roleArns) > 1 {
+			return errors.New("environment variables can only be printed for a single role")
+		}
+		_, credentials, err := retrieveCredentials(ctx, client, roleArn, true)
+		if err != nil {
+			return err
+		}
+		return writeEnvironmentVariables(credentials)
+	}
+
+	if err := aws_keyhub.CheckIfAwsConfigFileExists(); err != nil {
+		return err
+	}
+	var results []LoginResult
+	if multiple || allRoles || len(roleArns) > 1 {
+		results, err = loginMultipleRoles(ctx, client)
+	} else if result, singleErr := loginSingleRole(ctx, client, roleArn); singleErr != nil {
+		err = singleErr
+	} else {
+		results = append(results, result)
+	}
+	// The roles that were logged in with are reported, also when other roles failed.
+	if len(results) == 0 {
+		return err
+	}
+	if outputErr := writeOutput(cmd.OutOrStdout(), LoginReport{Context: client.Context(), Logins: results}, nil); outputErr != nil {
+		return outputErr
+	}
+	return err
+}
+
+// loginSingleRole writes the role with the ARN, or otherwise the selected role, to its profile.
+func loginSingleRole(ctx context.Context, client *aws_keyhub.Client, roleArn string) (LoginResult, error) {
+	// The cached session of the role ARN or profile is only reused up front when no other flag selects the role.
+	selectsRole := !roleSelector().IsEmpty()
+	if !selectsRole {
+		cachedCredentials, err := findCachedCredentials(client, roleArn)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		if cachedCredentials != nil {
+			return loginWithCachedCredentials(client, cachedCredentials)
+		}
+	}
+
+	samlAssertion, err := client.RetrieveSamlAssertion(ctx)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	selectedRoleAndPrincipal, err := client.SelectRoleAndPrincipal(roleSelector(roleArn), samlAssertion.RolesAndPrincipals)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if selectsRole || len(roleArn) == 0 {
+		// The role was selected by the other role selector flags or in the prompt.
+		if cachedCredentials, err := findCachedCredentials(client, selectedRoleAndPrincipal.Role); err != nil {
+			return LoginResult{}, err
+		} else if cachedCredentials != nil {
+			return loginWithCachedCredentials(client, cachedCredentials)
+		}
+	}
+	samlOutput, err := client.StsAssumeRoleWithSAML(ctx, selectedRoleAndPrincipal.Principal, selectedRoleAndPrincipal.Role, samlAssertion.Assertion)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	profileName, rememberProfile, err := singleRoleProfileName(client, selectedRoleAndPrincipal)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if err := writeProfile(client, profileName, rememberProfile, selectedRoleAndPrincipal, samlOutput.Credentials); err != nil {
+		return LoginResult{}, err
+	}
+	result := newLoginResult(profileName, selectedRoleAndPrincipal, samlOutput.Credentials)
+	if result.CallerIdentity, err = verifyProfile(ctx, client, profileName, selectedRoleAndPrincipal.Role); err != nil {
+		return LoginResult{}, err
+	}
+	logrus.Infof("Successfully logged in, use the AWS profile `%[1]s`. (export AWS_PROFILE=%[1]s / set AWS_PROFILE=%[1]s / $env:AWS_PROFILE='%[1]s')", profileName)
+	return result, nil
+}
+
+// loginWithCachedCredentials writes the cached credentials to the profile of the role.
+func loginWithCachedCredentials(client *aws_keyhub.Client, cachedCredentials *aws_keyhub.CachedCredentials) (LoginResult, error) {
+	profileName, rememberProfile, err := singleRoleProfileName(client, cachedCredentials.RolesAndPrincipals())
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if err := writeProfile(client, profileName, rememberProfile, cachedCredentials.RolesAndPrincipals(), &cachedCredentials.Credentials); err != nil {
+		return LoginResult{}, err
+	}
+	logrus.Infof("Reusing cached credentials for role %s, valid until %s. Use --force to login again.", cachedCredentials.RoleArn, cachedCredentials.Credentials.Expiration.Local().Format(time.RFC1123))
+	logrus.Infof("Successfully logged in, use the AWS profile `%[1]s`. (export AWS_PROFILE=%[1]s / set AWS_PROFILE=%[1]s / $env:AWS_PROFILE='%[1]s')", profileName)
+	result := newLoginResult(profileName, cachedCredentials.RolesAndPrincipals(), &cachedCredentials.Credentials)
+	result.Cached = true
+	return result, nil
+}
+
+func newLoginResult(profileName string, roleAndPrincipal aws_keyhub.RolesAndPrincipals, credentials *types.Credentials) LoginResult {
+	result := LoginResult{
+		Profile:      profileName,
+		RoleArn:      roleAndPrincipal.Role,
+		PrincipalArn: roleAndPrincipal.Principal,
+		Description:  roleAndPrincipal.Description,
+	}
+	if credentials.Expiration != nil {
+		result.Expiration = *credentials.Expiration
+	}
+	return result
+}
+
+// loginMultipleRoles assumes every selected role with a single SAML assertion and writes each role to its own profile.
+// When roles cannot be assumed, written or verified, the profiles of the other roles are still written and the
+// failures are returned with their results.
+func loginMultipleRoles(ctx context.Context, client *aws_keyhub.Client) ([]LoginResult, error) {
+	samlAssertion, err := client.RetrieveSamlAssertion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var selectedRolesAndPrincipals []aws_keyhub.RolesAndPrincipals
+	if allRoles {
+		selectedRolesAndPrincipals, err = client.MatchRoles(roleSelector(), samlAssertion.RolesAndPrincipals)
+	} else {
+		selectedRolesAndPrincipals, err = client.SelectRolesAndPrincipals(roleSelector(roleArns...), samlAssertion.RolesAndPrincipals)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	profileNames, err := multipleRolesProfileNames(client, profileTemplate, selectedRolesAndPrincipals)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []LoginResult
+	var errs []error
+	var rolesToAssume []aws_keyhub.RolesAndPrincipals
+	for _, roleAndPrincipal := range selectedRolesAndPrincipals {
+		profileName := profileNames[roleAndPrincipal.Role]
+		cachedCredentials, err := findCachedCredentials(client, roleAndPrincipal.Role)
+		if err != nil {
+			return nil, err
+		}
+		if cachedCredentials != nil {
+			if err := writeProfile(client, profileName, true, roleAndPrincipal, &cachedCredentials.Credentials); err != nil {
+				errs = append(errs, fmt.Errorf("role %s: %w", roleAndPrincipal.Role, err))
+				continue
+			}
+			logrus.Infof("Reusing cached credentials for role %s in profile `%s`.", roleAndPrincipal.Role, profileName)
+			result := newLoginResult(profileName, roleAndPrincipal, &cachedCredentials.Credentials)
+			result.Cached = true
+			results = append(results, result)
+			continue
+		}
+		rolesToAssume = append(rolesToAssume, roleAndPrincipal)
+	}
+
+	samlOutputs, assumeErr := client.StsAssumeRolesWithSAML(ctx, rolesToAssume, samlAssertion.Assertion)
+	if assumeErr != nil {
+		errs = append(errs, assumeErr)
+	}
+	for i, roleAndPrincipal := range rolesToAssume {
+		if samlOutputs[i] == nil {
+			continue
+		}
+		profileName := profileNames[roleAndPrincipal.Role]
+		if err := writeProfile(client, profileName, true, roleAndPrincipal, samlOutputs[i].Credentials); err != nil {
+			errs = append(errs, fmt.Errorf("role %s: %w", roleAndPrincipal.Role, err))
+			continue
+		}
+		result := newLoginResult(profileName, roleAndPrincipal, samlOutputs[i].Credentials)
+		if result.CallerIdentity, err = verifyProfile(ctx, client, profileName, roleAndPrincipal.Role); err != nil {
+			errs = append(errs, fmt.Errorf("role %s: %w", roleAndPrincipal.Role, err))
+			continue
+		}
+		logrus.Infof("Successfully logged in with role %s, use the AWS profile `%s`.", roleAndPrincipal.Role, profileName)
+		results = append(results, result)
+	}
+	if len(errs) > 0 {
+		logrus.Warnf("Logged in with %d of %d roles.", len(results), len(selectedRolesAndPrincipals))
+	}
+	return results, errors.Join(errs...)
+}
 
-	exchangeTokenResponse := aws_keyhub.ExchangeToken(loginResponse)
-	samlResponseDecoded := aws_keyhub.DecodeSAMLResponse(exchangeTokenResponse.AccessToken)
-	rolesAndPrincipals := aws_keyhub.RolesAndPrincipalsFromSamlResponse(samlResponseDecoded)
+// singleRoleProfileName returns the --profile parameter, or otherwise the profile based on the previous login or profile
+// template. It reports whether the profile should be remembered for the role, which is not the case for the
+// DefaultProfile every role falls back to: --all would write all roles remembered with it to the same profile.
+func singleRoleProfileName(client *aws_keyhub.Client, roleAndPrincipal aws_keyhub.RolesAndPrincipals) (string, bool, error) {
+	if len(profile) > 0 {
+		return profile, true, nil
+	}
+	profileName, err := client.ResolveProfileName(roleAndPrincipal, aws_keyhub.DefaultProfile, false)
+	if err != nil {
+		return "", false, err
+	}
+	_, remembered := client.Config.Aws.Profiles[roleAndPrincipal.Role]
+	return profileName, remembered || client.Config.Aws.ProfileTemplate != "", nil
+}
 
-	var samlOutput *sts.AssumeRoleWithSAMLOutput
+// multipleRolesProfileNames returns the profile per role ARN, the profiles have to be unique to not overwrite each other.
+func multipleRolesProfileNames(client *aws_keyhub.Client, profileTemplate string, rolesAndPrincipals []aws_keyhub.RolesAndPrincipals) (map[string]string, error) {
+	profileNames := make(map[string]string)
+	roleArnsByProfileName := make(map[string]string)
+	for _, roleAndPrincipal := range rolesAndPrincipals {
+		var profileName string
+		var err error
+		if len(profileTemplate) > 0 {
+			profileName, err = aws_keyhub.ProfileName(profileTemplate, roleAndPrincipal)
+		} else {
+			profileName, err = client.ResolveProfileName(roleAndPrincipal, aws_keyhub.DefaultProfileTemplate, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if otherRoleArn, exists := roleArnsByProfileName[profileName]; exists {
+			return nil, fmt.Errorf("roles %s and %s would both be written to profile `%s`, please use a --profile-template that results in unique profile names", otherRoleArn, roleAndPrincipal.Role, profileName)
+		}
+		roleArnsByProfileName[profileName] = roleAndPrincipal.Role
+		profileNames[roleAndPrincipal.Role] = profileName
+	}
+	return profileNames, nil
+}
 
-	selectedRoleAndPrincipal := aws_keyhub.SelectRoleAndPrincipal(roleArn, rolesAndPrincipals)
-	samlOutput = aws_keyhub.StsAssumeRoleWithSAML(ctx, selectedRoleAndPrincipal.Principal, selectedRoleAndPrincipal.Role, exchangeTokenResponse.AccessToken)
+// writeProfile writes the credentials to the profile and, when remember is set, remembers the profile for the role.
+// When the credentials are encrypted, the profile retrieves them from the encrypted credential cache with
+// credential_process instead.
+func writeProfile(client *aws_keyhub.Client, profileName string, remember bool, roleAndPrincipal aws_keyhub.RolesAndPrincipals, credentials *types.Credentials) error {
+	if client.Config.Aws.EncryptCredentials {
+		if err := aws_keyhub.WriteCredentialProcessProfile(profileName, credentialProcessCommand(client, roleAndPrincipal.Role)); err != nil {
+			return err
+		}
+		// Credentials in the credentials file take precedence over the credential process.
+		if err := aws_keyhub.RemoveCredentialFileProfile(profileName); err != nil {
+			return err
+		}
+	} else if err := aws_keyhub.WriteCredentialFile(profileName, credentials); err != nil {
+		return err
+	}
+	if err := client.StoreCachedCredentials(roleAndPrincipal, profileName, credentials); err != nil {
+		return err
+	}
+	if !remember {
+		return nil
+	}
+	return client.StoreProfileForRole(roleAndPrincipal.Role, profileName)
+}
+
+// verifyProfile checks that the profile has access to the role and returns its caller identity. Profiles with
+// encrypted credentials are not verified, the AWS SDK would run aws-keyhub credential-process to retrieve the
+// credentials that were just stored.
+func verifyProfile(ctx context.Context, client *aws_keyhub.Client, profileName string, roleArn string) (*CallerIdentity, error) {
+	if client.Config.Aws.EncryptCredentials {
+		return nil, nil
+	}
+	output, err := client.VerifyIfLoginWasSuccessful(ctx, profileName, roleArn)
+	if err != nil {
+		return nil, err
+	}
+	return &CallerIdentity{Account: aws.ToString(output.Account), Arn: aws.ToString(output.Arn), UserId: aws.ToString(output.UserId)}, nil
+}
 
-	aws_keyhub.WriteCredentialFile(profile, samlOutput.Credentials)
-	aws_keyhub.VerifyIfLoginWasSuccessful(ctx, profile, selectedRoleAndPrincipal.Role)
-	logrus.Infof("Successfully logged in, use the AWS profile `%[1]s`. (export AWS_PROFILE=%[1]s / set AWS_PROFILE=%[1]s / $env:AWS_PROFILE='%[1]s')", profile)
+func findCachedCredentials(client *aws_keyhub.Client, roleArn string) (*aws_keyhub.CachedCredentials, error) {
+	if force {
+		return nil, nil
+	}
+	if len(roleArn) > 0 {
+		return client.GetCachedCredentialsForRole(roleArn)
+	}
+	if len(profile) > 0 {
+		return client.GetCachedCredentialsForProfile(profile)
+	}
+	return nil, nil
 }
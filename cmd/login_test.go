@@ -0,0 +1,517 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/synctest"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+	"github.com/zalando/go-keyring"
+)
+
+var testRoles = []keyhubtest.Role{
+	{RoleArn: "arn:aws:iam::123456789012:role/Admin", PrincipalArn: "arn:aws:iam::123456789012:saml-provider/keyhub", Description: "production - admin"},
+	{RoleArn: "arn:aws:iam::210987654321:role/ReadOnly", PrincipalArn: "arn:aws:iam::210987654321:saml-provider/keyhub", Description: "acceptance - read only"},
+}
+
+// setupFakes configures aws-keyhub in an empty home directory to use a fake KeyHub and STS.
+func setupFakes(t *testing.T, roles ...keyhubtest.Role) (*keyhubtest.KeyHub, *keyhubtest.Sts) {
+	t.Helper()
+	home := keyhubtest.SetupHome(t)
+	keyhub := keyhubtest.NewKeyHub(roles...)
+	fakeSts := keyhubtest.NewSts()
+
+	config, err := json.Marshal(aws_keyhub.KeyhubConfigFile{
+		Keyhub: aws_keyhub.KeyhubConfig{Url: keyhub.URL, ClientId: keyhubtest.ClientId, AwsSamlClientId: keyhubtest.AwsSamlClientId},
+		Aws:    aws_keyhub.KeyhubAwsConfig{AssumeDuration: 3600},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".aws-keyhub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".aws-keyhub", "config-v2.json"), config, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	clientOptions = []aws_keyhub.ClientOption{
+		aws_keyhub.WithHTTPClient(keyhub.Client()),
+		aws_keyhub.WithBrowser(func(url string) error { return nil }),
+		aws_keyhub.WithStsClient(fakeSts.Client()),
+	}
+	isTerminal = func(file *os.File) bool { return true }
+	t.Cleanup(func() {
+		clientOptions = nil
+		isTerminal = defaultIsTerminal
+	})
+	return keyhub, fakeSts
+}
+
+var defaultIsTerminal = isTerminal
+
+// updateConfig changes the aws-keyhub configuration written by setupFakes.
+func updateConfig(t *testing.T, update func(config *aws_keyhub.KeyhubConfigFile)) {
+	t.Helper()
+	config, err := aws_keyhub.LoadAwsKeyHubConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	update(&config)
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(os.Getenv("HOME"), ".aws-keyhub", "config-v2.json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runCommand runs aws-keyhub with the arguments, the flags of earlier runs are reset first.
+func runCommand(t *testing.T, args ...string) error {
+	t.Helper()
+	resetFlags(rootCmd)
+	rootCmd.SetArgs(args)
+	return rootCmd.Execute()
+}
+
+// runCommandOutput runs aws-keyhub like runCommand and returns what the command wrote to its output.
+func runCommandOutput(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var output strings.Builder
+	rootCmd.SetOut(&output)
+	defer rootCmd.SetOut(nil)
+	err := runCommand(t, args...)
+	return output.String(), err
+}
+
+func resetFlags(cmd *cobra.Command) {
+	reset := func(flag *pflag.Flag) {
+		if sliceValue, ok := flag.Value.(pflag.SliceValue); ok {
+			sliceValue.Replace(nil)
+		} else {
+			flag.Value.Set(flag.DefValue)
+		}
+		flag.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, subCmd := range cmd.Commands() {
+		resetFlags(subCmd)
+	}
+}
+
+func readAccessKeyId(t *testing.T, profile string) string {
+	t.Helper()
+	accessKeyId, err := aws_keyhub.ReadCredentialFileAccessKeyId(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accessKeyId
+}
+
+func TestLogin(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub, fakeSts := setupFakes(t, testRoles...)
+		keyhub.DeviceCodeErrors = []string{"authorization_pending"}
+
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn); err != nil {
+			t.Fatal(err)
+		}
+
+		if accessKeyId := readAccessKeyId(t, aws_keyhub.DefaultProfile); !strings.HasPrefix(accessKeyId, "ASIAKEYHUBTEST") {
+			t.Errorf("profile %s has access key ID %q", aws_keyhub.DefaultProfile, accessKeyId)
+		}
+		if assumed := fakeSts.AssumedRoles(); len(assumed) != 1 || assumed[0] != testRoles[0].RoleArn {
+			t.Errorf("assumed roles %v", assumed)
+		}
+		refreshTokenPath, _ := aws_keyhub.GetAwsKeyHubRefreshTokenPath()
+		if _, err := os.Stat(refreshTokenPath); err != nil {
+			t.Errorf("refresh token not stored: %v", err)
+		}
+		config, err := aws_keyhub.LoadAwsKeyHubConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile, exists := config.Aws.Profiles[testRoles[0].RoleArn]; exists {
+			t.Errorf("remembered the fallback profile %q for the role", profile)
+		}
+	})
+}
+
+func TestLoginAllAfterSingleRoles(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		setupFakes(t, testRoles...)
+		for _, role := range testRoles {
+			if err := runCommand(t, "login", "--role-arn", role.RoleArn); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := runCommand(t, "login", "--all"); err != nil {
+			t.Fatal(err)
+		}
+
+		// A profile remembered for multiple roles by an earlier version is ignored for multiple roles.
+		updateConfig(t, func(config *aws_keyhub.KeyhubConfigFile) {
+			config.Aws.Profiles = map[string]string{testRoles[0].RoleArn: aws_keyhub.DefaultProfile, testRoles[1].RoleArn: aws_keyhub.DefaultProfile}
+		})
+		if err := runCommand(t, "login", "--all", "--force"); err != nil {
+			t.Fatal(err)
+		}
+		if err := runCommand(t, "sync-profiles"); err != nil {
+			t.Fatal(err)
+		}
+		for _, profile := range []string{"keyhub-123456789012-Admin", "keyhub-210987654321-ReadOnly"} {
+			if accessKeyId := readAccessKeyId(t, profile); accessKeyId == "" {
+				t.Errorf("profile %s not written by --all", profile)
+			}
+		}
+	})
+}
+
+func TestLoginReusesCachedCredentials(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub, fakeSts := setupFakes(t, testRoles...)
+
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn); err != nil {
+			t.Fatal(err)
+		}
+		accessKeyId := readAccessKeyId(t, aws_keyhub.DefaultProfile)
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn); err != nil {
+			t.Fatal(err)
+		}
+
+		if assumed := fakeSts.AssumedRoles(); len(assumed) != 1 {
+			t.Errorf("assumed roles %v, want the cached credentials to be reused", assumed)
+		}
+		if refreshes := keyhub.Requests("refresh_token"); refreshes != 0 {
+			t.Errorf("logged in to KeyHub %d times with the refresh token, want 0", refreshes)
+		}
+		if reused := readAccessKeyId(t, aws_keyhub.DefaultProfile); reused != accessKeyId {
+			t.Errorf("profile has access key ID %s, want the cached %s", reused, accessKeyId)
+		}
+	})
+}
+
+func TestLoginForceUsesRefreshToken(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub, fakeSts := setupFakes(t, testRoles...)
+
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn); err != nil {
+			t.Fatal(err)
+		}
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn, "--force"); err != nil {
+			t.Fatal(err)
+		}
+
+		if authorizations := keyhub.Requests("authorizedevice"); authorizations != 1 {
+			t.Errorf("authorized device %d times, want 1", authorizations)
+		}
+		if refreshes := keyhub.Requests("refresh_token"); refreshes != 1 {
+			t.Errorf("logged in to KeyHub %d times with the refresh token, want 1", refreshes)
+		}
+		if assumed := fakeSts.AssumedRoles(); len(assumed) != 2 {
+			t.Errorf("assumed roles %v, want the role to be assumed again", assumed)
+		}
+	})
+}
+
+func TestLoginAllRolesPartialFailure(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		_, fakeSts := setupFakes(t, testRoles...)
+		fakeSts.DeniedRoles = []string{testRoles[0].RoleArn}
+
+		output, err := runCommandOutput(t, "login", "--all", "--output", "json")
+		var stsError *aws_keyhub.StsError
+		if !errors.As(err, &stsError) || stsError.RoleArn != testRoles[0].RoleArn {
+			t.Errorf("got %v, want an StsError for %s", err, testRoles[0].RoleArn)
+		}
+		if accessKeyId := readAccessKeyId(t, "keyhub-210987654321-ReadOnly"); accessKeyId == "" {
+			t.Error("the profile of the role that could be assumed was not written")
+		}
+		var report LoginReport
+		if err := json.Unmarshal([]byte(output), &report); err != nil {
+			t.Fatalf("%v in:\n%s", err, output)
+		}
+		if len(report.Logins) != 1 || report.Logins[0].RoleArn != testRoles[1].RoleArn {
+			t.Errorf("logins %+v, want only the role that could be assumed", report.Logins)
+		}
+	})
+}
+
+func TestLoginAllRolesVerifyFailure(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		_, fakeSts := setupFakes(t, testRoles...)
+		fakeSts.RevokedRoles = []string{testRoles[0].RoleArn}
+
+		output, err := runCommandOutput(t, "login", "--all", "--output", "json")
+		if err == nil || !strings.Contains(err.Error(), testRoles[0].RoleArn) {
+			t.Errorf("got %v, want the verification error of %s", err, testRoles[0].RoleArn)
+		}
+		var report LoginReport
+		if err := json.Unmarshal([]byte(output), &report); err != nil {
+			t.Fatalf("%v in:\n%s", err, output)
+		}
+		if len(report.Logins) != 1 || report.Logins[0].RoleArn != testRoles[1].RoleArn {
+			t.Errorf("logins %+v, want the role that was verified", report.Logins)
+		}
+	})
+}
+
+func TestLoginOutput(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		setupFakes(t, testRoles...)
+
+		output, err := runCommandOutput(t, "login", "--role-arn", testRoles[0].RoleArn, "--output", "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report LoginReport
+		if err := json.Unmarshal([]byte(output), &report); err != nil {
+			t.Fatalf("%v in:\n%s", err, output)
+		}
+		if len(report.Logins) != 1 {
+			t.Fatalf("logins %+v, want one", report.Logins)
+		}
+		result := report.Logins[0]
+		if result.Profile != aws_keyhub.DefaultProfile || result.RoleArn != testRoles[0].RoleArn || result.PrincipalArn != testRoles[0].PrincipalArn || result.Cached {
+			t.Errorf("login result %+v", result)
+		}
+		if result.Expiration.IsZero() || result.CallerIdentity == nil || !strings.Contains(result.CallerIdentity.Arn, ":assumed-role/Admin/") {
+			t.Errorf("login result %+v, want the expiration and caller identity", result)
+		}
+
+		output, err = runCommandOutput(t, "login", "--role-arn", testRoles[0].RoleArn, "--output", "yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output, "    cached: true\n") || strings.Contains(output, "callerIdentity") {
+			t.Errorf("login yaml with cached credentials:\n%s", output)
+		}
+
+		output, err = runCommandOutput(t, "login", "--role-arn", testRoles[0].RoleArn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != "" {
+			t.Errorf("text output %q, want only logging", output)
+		}
+
+		if err := runCommand(t, "login", "--output", "xml"); err == nil || !strings.Contains(err.Error(), "unsupported output") {
+			t.Errorf("expected an unsupported output error, got %v", err)
+		}
+	})
+}
+
+func TestLoginAllRoles(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		_, fakeSts := setupFakes(t, testRoles...)
+
+		if err := runCommand(t, "login", "--all"); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, profile := range []string{"keyhub-123456789012-Admin", "keyhub-210987654321-ReadOnly"} {
+			if accessKeyId := readAccessKeyId(t, profile); accessKeyId == "" {
+				t.Errorf("no credentials in profile %s", profile)
+			}
+		}
+		if assumed := fakeSts.AssumedRoles(); len(assumed) != 2 {
+			t.Errorf("assumed roles %v", assumed)
+		}
+	})
+}
+
+func TestLoginUnknownRole(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		setupFakes(t, testRoles...)
+		unknownRoleArn := "arn:aws:iam::123456789012:role/Unknown"
+
+		err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn, "--role-arn", unknownRoleArn)
+
+		var roleNotFoundError *aws_keyhub.RoleNotFoundError
+		if !errors.As(err, &roleNotFoundError) || roleNotFoundError.RoleArn != unknownRoleArn {
+			t.Errorf("got %v, want a RoleNotFoundError for %s", err, unknownRoleArn)
+		}
+	})
+}
+
+func TestLoginFilter(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		_, fakeSts := setupFakes(t, testRoles...)
+
+		if err := runCommand(t, "login", "--filter", "acc read"); err != nil {
+			t.Fatal(err)
+		}
+
+		if assumed := fakeSts.AssumedRoles(); len(assumed) != 1 || assumed[0] != testRoles[1].RoleArn {
+			t.Errorf("assumed roles %v, want the only role matching the filter", assumed)
+		}
+		config, err := aws_keyhub.LoadAwsKeyHubConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(config.Aws.RecentRoles) != 1 || config.Aws.RecentRoles[0] != testRoles[1].RoleArn {
+			t.Errorf("recent roles %v", config.Aws.RecentRoles)
+		}
+
+		var noMatchingRoleError *aws_keyhub.NoMatchingRoleError
+		if err := runCommand(t, "login", "--filter", "nothing"); !errors.As(err, &noMatchingRoleError) {
+			t.Errorf("got %v, want a NoMatchingRoleError", err)
+		}
+	})
+}
+
+func TestLoginRoleSelectors(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		_, fakeSts := setupFakes(t, testRoles...)
+
+		if err := runCommand(t, "login", "--account", "210987654321", "--role-name", "readonly"); err != nil {
+			t.Fatal(err)
+		}
+		if err := runCommand(t, "login", "--all", "--role", "*admin*", "--profile-template", "{{.RoleName}}"); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{testRoles[1].RoleArn, testRoles[0].RoleArn}
+		if assumed := fakeSts.AssumedRoles(); !slices.Equal(assumed, expected) {
+			t.Errorf("assumed roles %v, want %v", assumed, expected)
+		}
+		if accessKeyId := readAccessKeyId(t, "ReadOnly"); accessKeyId != "" {
+			t.Error("--all logged in with a role that does not match --role")
+		}
+	})
+}
+
+func TestLoginRoleSelectorWithCachedProfile(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		_, fakeSts := setupFakes(t, testRoles...)
+
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn, "-p", "x"); err != nil {
+			t.Fatal(err)
+		}
+		// The cached session of the profile is for another role than the one selected.
+		if err := runCommand(t, "login", "-p", "x", "--account", "210987654321"); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{testRoles[0].RoleArn, testRoles[1].RoleArn}
+		if assumed := fakeSts.AssumedRoles(); !slices.Equal(assumed, expected) {
+			t.Errorf("assumed roles %v, want %v", assumed, expected)
+		}
+		client, err := loadClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cachedCredentials, err := client.GetCachedCredentialsForProfile("x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cachedCredentials == nil || cachedCredentials.RoleArn != testRoles[1].RoleArn {
+			t.Errorf("profile x has cached credentials %+v, want the role selected by --account", cachedCredentials)
+		}
+	})
+}
+
+func TestLoginAccessDenied(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub, fakeSts := setupFakes(t, testRoles...)
+		keyhub.DeviceCodeErrors = []string{"authorization_pending", "access_denied"}
+
+		err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn)
+
+		var keyhubError *aws_keyhub.KeyhubError
+		if !errors.As(err, &keyhubError) || keyhubError.ErrorCode != "access_denied" {
+			t.Errorf("got %v, want a KeyhubError with error code access_denied", err)
+		}
+		if !errors.Is(err, aws_keyhub.ErrAuthorizationDenied) {
+			t.Errorf("got %v, want ErrAuthorizationDenied", err)
+		}
+		if assumed := fakeSts.AssumedRoles(); len(assumed) != 0 {
+			t.Errorf("assumed roles %v after access was denied", assumed)
+		}
+	})
+}
+
+func TestLoginWithoutConfiguration(t *testing.T) {
+	keyhubtest.SetupHome(t)
+
+	err := runCommand(t, "login")
+
+	var configNotFoundError *aws_keyhub.ConfigNotFoundError
+	if !errors.As(err, &configNotFoundError) {
+		t.Errorf("got %v, want a ConfigNotFoundError", err)
+	}
+}
+
+func TestLoginNoBrowser(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		setupFakes(t, testRoles...)
+		var output strings.Builder
+		clientOptions = append(clientOptions,
+			aws_keyhub.WithBrowser(func(url string) error {
+				t.Errorf("opened %s in the browser", url)
+				return nil
+			}),
+			aws_keyhub.WithDeviceCodeOutput(&output))
+
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn, "--no-browser"); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output.String(), keyhubtest.UserCode) {
+			t.Errorf("user code not shown, got %q", output.String())
+		}
+	})
+}
+
+func TestLoginEncryptedCredentials(t *testing.T) {
+	keyring.MockInit()
+	synctest.Test(t, func(t *testing.T) {
+		_, fakeSts := setupFakes(t, testRoles...)
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn); err != nil {
+			t.Fatal(err)
+		}
+		updateConfig(t, func(config *aws_keyhub.KeyhubConfigFile) {
+			config.Keyhub.SecretStore = aws_keyhub.SecretStoreKeyring
+			config.Aws.EncryptCredentials = true
+		})
+
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn, "--force"); err != nil {
+			t.Fatal(err)
+		}
+		if accessKeyId := readAccessKeyId(t, aws_keyhub.DefaultProfile); accessKeyId != "" {
+			t.Errorf("profile %s still has access key ID %s in the credentials file", aws_keyhub.DefaultProfile, accessKeyId)
+		}
+		awsConfig, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".aws", "config"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(awsConfig), "credential-process --role-arn "+testRoles[0].RoleArn+" --context default") {
+			t.Errorf("profile does not use credential_process:\n%s", awsConfig)
+		}
+		home := os.Getenv("HOME")
+		if _, err := os.Stat(filepath.Join(home, ".aws-keyhub", "credential-cache.json")); !os.IsNotExist(err) {
+			t.Error("unencrypted credential cache not removed")
+		}
+		encryptedCache, err := os.ReadFile(filepath.Join(home, ".aws-keyhub", "credential-cache.json.enc"))
+		if err != nil || strings.Contains(string(encryptedCache), "ASIAKEYHUBTEST") {
+			t.Errorf("credential cache not encrypted: %v", err)
+		}
+
+		// The next login reuses the session from the encrypted credential cache.
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn); err != nil {
+			t.Fatal(err)
+		}
+		if assumed := fakeSts.AssumedRoles(); len(assumed) != 2 {
+			t.Errorf("assumed roles %v, want the encrypted cached credentials to be reused", assumed)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(logoutCmd)
+	logoutCmd.Flags().StringArrayVarP(&logoutProfiles, "profile", "p", nil, "only remove the credentials login wrote to the profile, repeat to remove multiple profiles")
+	logoutCmd.Flags().BoolVarP(&logoutAll, "all", "a", false, "also remove the credentials login wrote to all profiles")
+	logoutCmd.MarkFlagsMutuallyExclusive("profile", "all")
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "logout from KeyHub and remove the stored credentials",
+	Long: `Revokes the KeyHub refresh token of the context and removes it, together with the cached AWS sessions.
+The profiles written by login keep their credentials until they expire, use --all to remove them as well,
+or --profile to only remove the credentials of a profile. Only the access key, secret access key and session
+token written by aws-keyhub are removed, other profiles and settings in ~/.aws/credentials are left alone.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return logout(cmd.Context())
+	},
+}
+
+var logoutProfiles []string
+var logoutAll bool
+
+func logout(ctx context.Context) error {
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+
+	profiles := logoutProfiles
+	if logoutAll {
+		trackedProfiles, err := client.GetTrackedProfiles()
+		if err != nil {
+			return err
+		}
+		for _, tracked := range trackedProfiles {
+			profiles = append(profiles, tracked.Profile)
+		}
+	}
+	for i, profile := range profiles {
+		// A profile is listed once for every role it was tracked for.
+		if slices.Contains(profiles[:i], profile) {
+			continue
+		}
+		removed, err := client.RemoveProfile(profile)
+		if err != nil {
+			return err
+		}
+		if removed {
+			logrus.Infof("Removed the credentials of profile '%s'.", profile)
+		} else {
+			logrus.Warnf("Profile '%s' was not written by `aws-keyhub login`, leaving it alone.", profile)
+		}
+	}
+	if len(logoutProfiles) > 0 {
+		return nil
+	}
+
+	if err := client.RevokeRefreshToken(ctx); err != nil {
+		return err
+	}
+	if err := client.ClearCredentialCache(!logoutAll); err != nil {
+		return err
+	}
+	logrus.Infoln("Logged out from KeyHub.")
+	return nil
+}
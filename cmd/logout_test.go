@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/synctest"
+
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+	"github.com/zalando/go-keyring"
+)
+
+func TestLogout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub, _ := setupFakes(t, testRoles...)
+		adminProfile, readOnlyProfile := "keyhub-123456789012-Admin", "keyhub-210987654321-ReadOnly"
+
+		if err := runCommand(t, "login", "--all"); err != nil {
+			t.Fatal(err)
+		}
+		refreshTokenPath, _ := aws_keyhub.GetAwsKeyHubRefreshTokenPath()
+
+		if err := runCommand(t, "logout", "--profile", adminProfile); err != nil {
+			t.Fatal(err)
+		}
+		if accessKeyId := readAccessKeyId(t, adminProfile); accessKeyId != "" {
+			t.Errorf("profile %s still has access key ID %s", adminProfile, accessKeyId)
+		}
+		if accessKeyId := readAccessKeyId(t, readOnlyProfile); accessKeyId == "" {
+			t.Errorf("profile %s was removed with --profile %s", readOnlyProfile, adminProfile)
+		}
+		if _, err := os.Stat(refreshTokenPath); err != nil {
+			t.Errorf("refresh token removed with --profile: %v", err)
+		}
+
+		if err := runCommand(t, "logout"); err != nil {
+			t.Fatal(err)
+		}
+		if revocations := keyhub.Requests("revoke"); revocations != 1 {
+			t.Errorf("revoked %d times, want 1", revocations)
+		}
+		if _, err := os.Stat(refreshTokenPath); !os.IsNotExist(err) {
+			t.Errorf("refresh token not removed: %v", err)
+		}
+		if accessKeyId := readAccessKeyId(t, readOnlyProfile); accessKeyId == "" {
+			t.Errorf("profile %s was removed without --all", readOnlyProfile)
+		}
+
+		if err := runCommand(t, "logout", "--all"); err != nil {
+			t.Fatal(err)
+		}
+		if accessKeyId := readAccessKeyId(t, readOnlyProfile); accessKeyId != "" {
+			t.Errorf("profile %s still has access key ID %s after --all", readOnlyProfile, accessKeyId)
+		}
+	})
+}
+
+func TestLogoutEncryptedCredentials(t *testing.T) {
+	keyring.MockInit()
+	synctest.Test(t, func(t *testing.T) {
+		setupFakes(t, testRoles...)
+		updateConfig(t, func(config *aws_keyhub.KeyhubConfigFile) {
+			config.Keyhub.SecretStore = aws_keyhub.SecretStoreKeyring
+			config.Aws.EncryptCredentials = true
+		})
+		if err := runCommand(t, "login", "--all"); err != nil {
+			t.Fatal(err)
+		}
+		awsConfigPath := filepath.Join(os.Getenv("HOME"), ".aws", "config")
+		if awsConfig, _ := os.ReadFile(awsConfigPath); !strings.Contains(string(awsConfig), "credential-process") {
+			t.Fatalf("login did not write credential_process:\n%s", awsConfig)
+		}
+
+		if err := runCommand(t, "logout", "--all"); err != nil {
+			t.Fatal(err)
+		}
+		awsConfig, err := os.ReadFile(awsConfigPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(awsConfig), "credential-process") {
+			t.Errorf("credential_process not removed with --all:\n%s", awsConfig)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The formats of the global --output flag.
+const (
+	OutputText = "text"
+	OutputJson = "json"
+	OutputYaml = "yaml"
+)
+
+var outputFormat string
+
+// checkOutputFormat returns an error when the --output flag is not text, json, yaml or one of the formats the command
+// supports in addition. It is checked before the command does anything, a typo should not cost a KeyHub login.
+func checkOutputFormat(commandFormats ...string) error {
+	formats := append([]string{OutputText, OutputJson, OutputYaml}, commandFormats...)
+	if !slices.Contains(formats, outputFormat) {
+		return fmt.Errorf("unsupported output '%s', use one of %s", outputFormat, strings.Join(formats, ", "))
+	}
+	return nil
+}
+
+// writeOutput writes the document as JSON or YAML, or otherwise with writeText. The JSON field names are used for
+// YAML as well. A nil writeText writes nothing, for commands that only log in text mode.
+func writeOutput(w io.Writer, document any, writeText func(w io.Writer) error) error {
+	switch outputFormat {
+	case OutputJson:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(document)
+	case OutputYaml:
+		return writeYaml(w, document)
+	}
+	if writeText == nil {
+		return nil
+	}
+	return writeText(w)
+}
+
+// writeYaml writes the document as YAML with the field names and order of its JSON encoding.
+func writeYaml(w io.Writer, document any) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	// JSON is YAML in flow style, parsing it keeps the field order.
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	resetStyle(&node)
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// resetStyle switches the node and its children from the JSON flow style and quoting to the default block style.
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
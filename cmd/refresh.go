@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func init() {
+	rootCmd.AddCommand(refreshCmd)
+	refreshCmd.Flags().BoolVarP(&refreshDaemon, "daemon", "d", false, "keep running and refresh the profiles shortly before they expire")
+	refreshCmd.Flags().DurationVar(&refreshInterval, "interval", time.Minute, "how often the daemon checks for profiles that are about to expire")
+	refreshCmd.Flags().BoolVarP(&force, "force", "f", false, "refresh all profiles, even if their credentials are still valid")
+}
+
+var refreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "refresh the profiles written by login",
+	Long: `Retrieves new credentials for the profiles written by login that are about to expire, using the KeyHub
+refresh token. With --daemon aws-keyhub keeps running and refreshes the profiles shortly before they expire,
+for as long as the KeyHub refresh token is valid.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return refresh(cmd.Context())
+	},
+}
+
+var refreshDaemon bool
+var refreshInterval time.Duration
+
+// refreshRetryDelay is the first delay before the daemon retries after a failed refresh, it doubles up to the interval.
+const refreshRetryDelay = 5 * time.Second
+
+func refresh(ctx context.Context) error {
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	if err := aws_keyhub.CheckIfAwsConfigFileExists(); err != nil {
+		return err
+	}
+	if !refreshDaemon {
+		return refreshProfiles(ctx, client)
+	}
+
+	logrus.Infof("Refreshing profiles before they expire, checking every %s.", refreshInterval)
+	authorized := true
+	retryDelay := refreshRetryDelay
+	for {
+		wasAuthorized := authorized
+		err := refreshProfiles(ctx, client)
+		authorized = !errors.Is(err, aws_keyhub.ErrAuthorizationRequired)
+		wait := refreshInterval
+		if wasAuthorized && !authorized {
+			logrus.Warnln("The KeyHub refresh token is no longer valid, please run `aws-keyhub login` to authorize aws-keyhub again.")
+		} else if err != nil && authorized {
+			// For example KeyHub is unavailable, the refresh token is kept and used again with exponential backoff.
+			wait = min(retryDelay, refreshInterval)
+			retryDelay *= 2
+			logrus.Errorf("Failed to refresh profiles, retrying in %s. %v", wait, err)
+		} else {
+			retryDelay = refreshRetryDelay
+		}
+		force = false
+		select {
+		case <-ctx.Done():
+			logrus.Infoln("Stopped refreshing profiles.")
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// refreshProfiles refreshes the tracked profiles that are about to expire. It returns ErrAuthorizationRequired when
+// they could not be refreshed because the user has to authorize aws-keyhub again.
+func refreshProfiles(ctx context.Context, client *aws_keyhub.Client) error {
+	trackedProfiles, err := client.GetTrackedProfiles()
+	if err != nil {
+		return err
+	}
+	var due []aws_keyhub.CachedCredentials
+	for _, tracked := range trackedProfiles {
+		if !force && client.IsCachedCredentialValid(tracked) {
+			continue
+		}
+		// Encrypted credentials are never written to the credentials file, only to the credential cache.
+		if !client.Config.Aws.EncryptCredentials {
+			accessKeyId, err := aws_keyhub.ReadCredentialFileAccessKeyId(tracked.Profile)
+			if err != nil {
+				return err
+			}
+			if accessKeyId != *tracked.Credentials.AccessKeyId {
+				logrus.Debugf("Not refreshing profile `%s`, it was changed outside of aws-keyhub.", tracked.Profile)
+				continue
+			}
+		}
+		due = append(due, tracked)
+	}
+	if len(due) == 0 {
+		logrus.Debugln("No profiles to refresh.")
+		return nil
+	}
+
+	loginResponse, err := client.DoLoginWithRefreshToken(ctx)
+	if err != nil {
+		return err
+	}
+	samlAssertion, err := client.ExchangeForSamlAssertion(ctx, loginResponse)
+	if err != nil {
+		return err
+	}
+
+	var rolesToAssume []aws_keyhub.RolesAndPrincipals
+	var profileNames []string
+	for _, tracked := range due {
+		roleAndPrincipal, err := aws_keyhub.FindRoleAndPrincipalByRoleArn(tracked.RoleArn, samlAssertion.RolesAndPrincipals)
+		if err != nil {
+			logrus.Warnf("Not refreshing profile `%s`, role %s is no longer available.", tracked.Profile, tracked.RoleArn)
+			continue
+		}
+		rolesToAssume = append(rolesToAssume, roleAndPrincipal)
+		profileNames = append(profileNames, tracked.Profile)
+	}
+
+	// The profiles whose role could be assumed are refreshed, also when other roles failed.
+	samlOutputs, assumeErr := client.StsAssumeRolesWithSAML(ctx, rolesToAssume, samlAssertion.Assertion)
+	for i, roleAndPrincipal := range rolesToAssume {
+		if samlOutputs[i] == nil {
+			logrus.Warnf("Not refreshing profile `%s`, role %s could not be assumed.", profileNames[i], roleAndPrincipal.Role)
+			continue
+		}
+		if !client.Config.Aws.EncryptCredentials {
+			if err := aws_keyhub.WriteCredentialFile(profileNames[i], samlOutputs[i].Credentials); err != nil {
+				return err
+			}
+		}
+		if err := client.StoreCachedCredentials(roleAndPrincipal, profileNames[i], samlOutputs[i].Credentials); err != nil {
+			return err
+		}
+		logrus.Infof("Refreshed profile `%s`, valid until %s.", profileNames[i], samlOutputs[i].Credentials.Expiration.Local().Format(time.RFC1123))
+	}
+	return assumeErr
+}
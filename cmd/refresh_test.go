@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"testing/synctest"
+
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func TestRefresh(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		prepare    func(keyhub *keyhubtest.KeyHub)
+		refreshed  bool
+		keepsToken bool
+	}{
+		{name: "200 OK", prepare: func(keyhub *keyhubtest.KeyHub) {}, refreshed: true, keepsToken: true},
+		{name: "400 invalid_grant", prepare: func(keyhub *keyhubtest.KeyHub) { keyhub.RevokeRefreshTokens() }},
+		{name: "503 Service Unavailable", prepare: func(keyhub *keyhubtest.KeyHub) { keyhub.RefreshTokenStatus = http.StatusServiceUnavailable }, keepsToken: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				keyhub, fakeSts := setupFakes(t, testRoles...)
+				if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn); err != nil {
+					t.Fatal(err)
+				}
+				refreshTokenPath, _ := aws_keyhub.GetAwsKeyHubRefreshTokenPath()
+				test.prepare(keyhub)
+
+				err := runCommand(t, "refresh", "--force")
+				switch {
+				case test.refreshed && err != nil:
+					t.Fatal(err)
+				case !test.refreshed && err == nil:
+					t.Fatal("refreshed the profiles")
+				case !test.refreshed && test.keepsToken == errors.Is(err, aws_keyhub.ErrAuthorizationRequired):
+					t.Errorf("error %v, authorization required only when KeyHub rejected the refresh token", err)
+				}
+				if assumed := fakeSts.AssumedRoles(); test.refreshed != (len(assumed) == 2) {
+					t.Errorf("assumed roles %v", assumed)
+				}
+				if _, err := os.Stat(refreshTokenPath); test.keepsToken != (err == nil) {
+					t.Errorf("refresh token stored: %v, want %t", err, test.keepsToken)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func init() {
+	rootCmd.AddCommand(rolesCmd)
+	addRoleSelectorFlags(rolesCmd)
+}
+
+// RolesOutputCsv is the format roles supports as --output in addition to text, json and yaml.
+const RolesOutputCsv = "csv"
+
+var rolesCmd = &cobra.Command{
+	Use:   "roles",
+	Short: "list the roles you have access to",
+	Long: `Logs in to KeyHub and lists the roles in the SAML assertion, without assuming any of them. For every role
+the role ARN, principal ARN, account ID, role name and description are shown as a table, or written as json,
+yaml or csv with --output. Use --account, --role-name, --role or --filter to only list the matching roles.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return roles(cmd)
+	},
+}
+
+// RolesReport is the document roles writes with --output json or yaml.
+type RolesReport struct {
+	Context string `json:"context"`
+	Roles   []Role `json:"roles"`
+}
+
+// Role is a role in the SAML assertion received from KeyHub.
+type Role struct {
+	RoleArn      string `json:"roleArn"`
+	PrincipalArn string `json:"principalArn"`
+	AccountId    string `json:"accountId"`
+	AccountAlias string `json:"accountAlias,omitempty"`
+	RoleName     string `json:"roleName"`
+	Description  string `json:"description,omitempty"`
+}
+
+func roles(cmd *cobra.Command) error {
+	if err := checkOutputFormat(RolesOutputCsv); err != nil {
+		return err
+	}
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	samlAssertion, err := client.RetrieveSamlAssertion(cmd.Context())
+	if err != nil {
+		return err
+	}
+	rolesAndPrincipals := aws_keyhub.SortedRolesAndPrincipals(samlAssertion.RolesAndPrincipals)
+	if selector := roleSelector(); !selector.IsEmpty() {
+		if rolesAndPrincipals, err = client.MatchRoles(selector, samlAssertion.RolesAndPrincipals); err != nil {
+			return err
+		}
+	}
+
+	report := RolesReport{Context: client.Context(), Roles: []Role{}}
+	for _, roleAndPrincipal := range rolesAndPrincipals {
+		data := aws_keyhub.NewProfileNameData(roleAndPrincipal)
+		report.Roles = append(report.Roles, Role{
+			RoleArn:      data.RoleArn,
+			PrincipalArn: data.PrincipalArn,
+			AccountId:    data.AccountId,
+			AccountAlias: client.Config.Aws.AccountAliases[data.AccountId],
+			RoleName:     data.RoleName,
+			Description:  data.Description,
+		})
+	}
+	if outputFormat == RolesOutputCsv {
+		return writeRolesCsv(cmd.OutOrStdout(), report.Roles)
+	}
+	return writeOutput(cmd.OutOrStdout(), report, func(w io.Writer) error {
+		return writeRolesTable(w, report.Roles)
+	})
+}
+
+func writeRolesTable(w io.Writer, roles []Role) error {
+	if len(roles) == 0 {
+		fmt.Fprintln(w, "No roles available in the SAML assertion received from KeyHub.")
+		return nil
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ACCOUNT\tROLE NAME\tDESCRIPTION\tROLE ARN\tPRINCIPAL ARN")
+	for _, role := range roles {
+		account := role.AccountId
+		if role.AccountAlias != "" {
+			account = role.AccountAlias + " (" + role.AccountId + ")"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", account, role.RoleName, role.Description, role.RoleArn, role.PrincipalArn)
+	}
+	return table.Flush()
+}
+
+func writeRolesCsv(w io.Writer, roles []Role) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"role_arn", "principal_arn", "account_id", "account_alias", "role_name", "description"})
+	for _, role := range roles {
+		writer.Write([]string{role.RoleArn, role.PrincipalArn, role.AccountId, role.AccountAlias, role.RoleName, role.Description})
+	}
+	writer.Flush()
+	return writer.Error()
+}
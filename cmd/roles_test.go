@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/synctest"
+
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func TestRoles(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		_, fakeSts := setupFakes(t, testRoles...)
+		updateConfig(t, func(config *aws_keyhub.KeyhubConfigFile) {
+			config.Aws.AccountAliases = map[string]string{"123456789012": "production"}
+		})
+
+		output, err := runCommandOutput(t, "roles")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output, "production (123456789012)  Admin ") || !strings.Contains(output, testRoles[1].RoleArn) {
+			t.Errorf("roles table:\n%s", output)
+		}
+
+		output, err = runCommandOutput(t, "roles", "--output", "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report RolesReport
+		if err := json.Unmarshal([]byte(output), &report); err != nil {
+			t.Fatalf("%v in:\n%s", err, output)
+		}
+		expected := Role{
+			RoleArn:      testRoles[0].RoleArn,
+			PrincipalArn: testRoles[0].PrincipalArn,
+			AccountId:    "123456789012",
+			AccountAlias: "production",
+			RoleName:     "Admin",
+			Description:  testRoles[0].Description,
+		}
+		if len(report.Roles) != 2 || report.Roles[0] != expected {
+			t.Errorf("roles %+v, want %+v first", report.Roles, expected)
+		}
+
+		output, err = runCommandOutput(t, "roles", "--output", "csv", "--role-name", "readonly")
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(strings.NewReader(output)).ReadAll()
+		if err != nil {
+			t.Fatalf("%v in:\n%s", err, output)
+		}
+		if len(records) != 2 || records[0][0] != "role_arn" || records[1][0] != testRoles[1].RoleArn || records[1][4] != "ReadOnly" {
+			t.Errorf("roles csv %q", records)
+		}
+
+		if assumed := fakeSts.AssumedRoles(); len(assumed) != 0 {
+			t.Errorf("assumed roles %v, want none", assumed)
+		}
+	})
+}
@@ -1,7 +1,16 @@
 package cmd
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cli/browser"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+	"golang.org/x/term"
 )
 
 var (
@@ -11,11 +20,77 @@ var (
 		Long: `aws-keyhub retrieves temporary (session) credentials by using Topicus KeyHub. By doing a 
 OAuth2 token exchange for the SAML assertion with KeyHub. This SAML assertion is then used to retrieve
 credentials from AWS STS.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			reserveStdout()
+			if len(KeyhubContext) > 0 {
+				return aws_keyhub.SetContext(KeyhubContext)
+			}
+			return nil
+		},
+		// Errors are logged by Execute, the usage is only relevant for invalid flags and arguments.
+		SilenceErrors: true,
+		SilenceUsage:  true,
 	}
 )
 var Verbose bool
+var KeyhubContext string
+var noBrowser bool
+var nonInteractive bool
 
-func Execute() error {
+// isTerminal reports whether the file is a terminal, the tests replace it to run the commands interactively.
+var isTerminal = func(file *os.File) bool {
+	return term.IsTerminal(int(file.Fd()))
+}
+
+// clientOptions are passed to every KeyHub client the commands create, the tests use them to inject fakes.
+var clientOptions []aws_keyhub.ClientOption
+
+func init() {
 	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "verbose output")
-	return rootCmd.Execute()
+	rootCmd.PersistentFlags().StringVarP(&KeyhubContext, "context", "c", "", "aws-keyhub context (KeyHub configuration) to use instead of the current context")
+	rootCmd.PersistentFlags().BoolVar(&noBrowser, "no-browser", false, "do not open the browser to authorize aws-keyhub, show the URL, code and a QR code instead (default in an SSH session or without a graphical desktop)")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", OutputText, "output format of login, status, roles and sync-profiles: text, json or yaml, logging always goes to stderr")
+	rootCmd.PersistentFlags().BoolVar(&nonInteractive, "non-interactive", false, "never prompt or ask to authorize aws-keyhub, fail with a distinct exit code when a setting, role or authorization is missing (default when stdin is not a terminal, except that credential-process still asks to authorize aws-keyhub in the browser)")
+}
+
+// Execute runs aws-keyhub. Interrupting it (Ctrl-C) or terminating it cancels the context of the command, which aborts
+// a pending KeyHub login and stops the serve and refresh commands.
+func Execute() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := rootCmd.ExecuteContext(ctx)
+	if err != nil {
+		logrus.Errorln(err)
+	}
+	return err
+}
+
+// reserveStdout makes sure nothing but the output of the command is written to stdout, so it can be consumed by other tools.
+func reserveStdout() {
+	logrus.SetOutput(os.Stderr)
+	browser.Stdout = os.Stderr
+}
+
+// loadClient creates a KeyHub client with the configuration of the current context. In non-interactive mode the client
+// never prompts nor asks the user to authorize aws-keyhub.
+func loadClient(extraOptions ...aws_keyhub.ClientOption) (*aws_keyhub.Client, error) {
+	if isNonInteractive() {
+		extraOptions = append(extraOptions, aws_keyhub.WithoutPrompts(), aws_keyhub.WithoutAuthorization())
+	}
+	return newClient(extraOptions...)
+}
+
+// newClient creates a KeyHub client like loadClient, but leaves the non-interactive mode to the caller.
+func newClient(extraOptions ...aws_keyhub.ClientOption) (*aws_keyhub.Client, error) {
+	options := append(clientOptions[:len(clientOptions):len(clientOptions)], extraOptions...)
+	if noBrowser {
+		options = append(options, aws_keyhub.WithoutBrowser())
+	}
+	return aws_keyhub.LoadClient(options...)
+}
+
+// isNonInteractive reports whether every input has to come from flags, the environment or the configuration: with
+// --non-interactive, or when stdin is not a terminal.
+func isNonInteractive() bool {
+	return nonInteractive || !isTerminal(os.Stdin)
 }
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVarP(&serveRoleArn, "role-arn", "r", "", "serve credentials for the specified role ARN instead of asking for the role")
+	serveCmd.Flags().StringVar(&serveAddress, "address", "127.0.0.1", "loopback address to listen on, the AWS SDKs only accept loopback addresses and the credentials must not be reachable from the network")
+	serveCmd.Flags().IntVar(&servePort, "port", 0, "port to listen on, a free port is chosen when omitted")
+	serveCmd.Flags().BoolVar(&serveImds, "imds", false, "also emulate the IMDSv2 credentials endpoint (AWS_EC2_METADATA_SERVICE_ENDPOINT), note that IMDS requests are not protected by the authorization token")
+	addRoleSelectorFlags(serveCmd)
+	addEnvFlags(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve credentials over HTTP",
+	Long: `Starts a local HTTP server that serves AWS credentials in the format of the container credentials
+endpoint (AWS_CONTAINER_CREDENTIALS_FULL_URI). New credentials are retrieved shortly before the current
+ones expire, so long-running tools keep working as long as the KeyHub refresh token is valid.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return serve(cmd.Context())
+	},
+}
+
+var serveRoleArn string
+var serveAddress string
+var servePort int
+var serveImds bool
+
+func serve(ctx context.Context) error {
+	if !aws_keyhub.IsLoopbackAddress(serveAddress) {
+		return fmt.Errorf("refusing to serve credentials on %s, only loopback addresses like 127.0.0.1 and ::1 are allowed", serveAddress)
+	}
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+
+	server := &aws_keyhub.CredentialServer{
+		AuthorizationToken: aws_keyhub.NewAuthorizationToken(),
+		EnableIMDS:         serveImds,
+		CacheMargin:        client.CacheMargin(),
+		Context:            ctx,
+		Retrieve: func(ctx context.Context) (aws_keyhub.RolesAndPrincipals, *types.Credentials, error) {
+			roleAndPrincipal, credentials, err := retrieveCredentials(ctx, client, serveRoleArn, true)
+			if err != nil {
+				return aws_keyhub.RolesAndPrincipals{}, nil, err
+			}
+			// Keep serving the role that was chosen in the prompt when refreshing.
+			serveRoleArn = roleAndPrincipal.Role
+			return roleAndPrincipal, credentials, nil
+		},
+	}
+	// Retrieve the credentials up front, so a prompt or KeyHub authorization does not block the first request.
+	if _, _, err := server.Credentials(ctx); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(serveAddress, fmt.Sprint(servePort)))
+	if err != nil {
+		return fmt.Errorf("failed to start credential server: %w", err)
+	}
+	baseUrl := "http://" + listener.Addr().String()
+
+	variables := [][2]string{
+		{"AWS_CONTAINER_CREDENTIALS_FULL_URI", baseUrl + aws_keyhub.CredentialServerPath},
+		{"AWS_CONTAINER_AUTHORIZATION_TOKEN", server.AuthorizationToken},
+	}
+	if serveImds {
+		variables = append(variables, [2]string{"AWS_EC2_METADATA_SERVICE_ENDPOINT", baseUrl + "/"})
+	}
+	if envRegion != "" {
+		variables = append(variables, [2]string{"AWS_REGION", envRegion}, [2]string{"AWS_DEFAULT_REGION", envRegion})
+	}
+	shell := envShell
+	if shell == "" {
+		shell = aws_keyhub.DetectShell()
+	}
+	logrus.Infoln("Serving credentials on", baseUrl, "use the following environment variables:")
+	if err := aws_keyhub.WriteVariables(os.Stdout, shell, variables); err != nil {
+		return err
+	}
+
+	go server.RefreshBeforeExpiry(ctx)
+
+	httpServer := &http.Server{Handler: server.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("credential server failed: %w", err)
+	}
+	logrus.Infoln("Credential server stopped.")
+	return nil
+}
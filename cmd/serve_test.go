@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServeRejectsNetworkAddress(t *testing.T) {
+	_, fakeSts := setupFakes(t, testRoles...)
+
+	for _, address := range []string{"0.0.0.0", "::", "192.168.1.10", "keyhub.example.com"} {
+		err := runCommand(t, "serve", "--role-arn", testRoles[0].RoleArn, "--address", address)
+		if err == nil || !strings.Contains(err.Error(), "only loopback addresses") {
+			t.Errorf("serve on %s returned %v, want an error", address, err)
+		}
+	}
+	if assumed := fakeSts.AssumedRoles(); len(assumed) != 0 {
+		t.Errorf("assumed roles %v before refusing the address", assumed)
+	}
+}
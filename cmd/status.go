@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVar(&statusVerify, "verify", false, "verify the sessions with AWS STS GetCallerIdentity")
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show the refresh token and the profiles written by login",
+	Long: `Shows whether the KeyHub refresh token of the context is valid, and the profiles written by login with
+the role and expiration of their session. A profile is "changed" when its credentials were replaced outside of
+aws-keyhub. Use --verify to check the sessions with AWS STS GetCallerIdentity.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return status(cmd)
+	},
+}
+
+var statusVerify bool
+
+func status(cmd *cobra.Command) error {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	status, err := client.Status(cmd.Context(), statusVerify)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(cmd.OutOrStdout(), status, func(w io.Writer) error {
+		return writeStatusTable(w, status, statusVerify)
+	})
+}
+
+func writeStatusTable(w io.Writer, status *aws_keyhub.Status, verified bool) error {
+	fmt.Fprintf(w, "Context:       %s\n", status.Context)
+	refreshToken := status.RefreshToken
+	switch {
+	case refreshToken.Error != "":
+		fmt.Fprintf(w, "Refresh token: unknown, %s (%s)\n", refreshToken.Error, refreshToken.SecretStore)
+	case !refreshToken.Stored:
+		fmt.Fprintf(w, "Refresh token: none, run `aws-keyhub login` to authorize aws-keyhub (%s)\n", refreshToken.SecretStore)
+	case refreshToken.Valid:
+		fmt.Fprintf(w, "Refresh token: valid until %s (%s)\n", formatTime(*refreshToken.ExpireDate), refreshToken.SecretStore)
+	default:
+		fmt.Fprintf(w, "Refresh token: expired at %s, run `aws-keyhub login` to authorize aws-keyhub again (%s)\n", formatTime(*refreshToken.ExpireDate), refreshToken.SecretStore)
+	}
+	fmt.Fprintln(w)
+	if len(status.Profiles) == 0 {
+		fmt.Fprintln(w, "No profiles written by `aws-keyhub login`.")
+		return nil
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "PROFILE\tROLE\tDESCRIPTION\tEXPIRES\tSTATE"
+	if verified {
+		header += "\tCALLER"
+	}
+	fmt.Fprintln(table, header)
+	for _, profile := range status.Profiles {
+		row := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", profile.Profile, profile.RoleArn, profile.Description, formatTime(profile.Expiration), profile.State)
+		if verified {
+			caller := profile.CallerArn
+			if profile.VerifyError != "" {
+				caller = "error: " + profile.VerifyError
+			}
+			row += "\t" + caller
+		}
+		fmt.Fprintln(table, row)
+	}
+	return table.Flush()
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format(time.RFC1123)
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/synctest"
+
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func TestStatus(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		setupFakes(t, testRoles...)
+
+		output, err := runCommandOutput(t, "status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output, "Refresh token: none") || !strings.Contains(output, "No profiles") {
+			t.Errorf("status before login:\n%s", output)
+		}
+
+		if err := runCommand(t, "login", "--role-arn", testRoles[0].RoleArn); err != nil {
+			t.Fatal(err)
+		}
+		output, err = runCommandOutput(t, "status", "--verify", "--output", "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status aws_keyhub.Status
+		if err := json.Unmarshal([]byte(output), &status); err != nil {
+			t.Fatalf("%v in:\n%s", err, output)
+		}
+		if !status.RefreshToken.Valid || status.RefreshToken.SecretStore != aws_keyhub.SecretStoreFile {
+			t.Errorf("refresh token status %+v", status.RefreshToken)
+		}
+		if len(status.Profiles) != 1 {
+			t.Fatalf("profiles %+v, want the profile written by login", status.Profiles)
+		}
+		profile := status.Profiles[0]
+		if profile.Profile != aws_keyhub.DefaultProfile || profile.RoleArn != testRoles[0].RoleArn || profile.State != aws_keyhub.ProfileStateValid {
+			t.Errorf("profile status %+v", profile)
+		}
+		if !strings.Contains(profile.CallerArn, ":assumed-role/Admin/") {
+			t.Errorf("caller %q, %q, want the assumed role", profile.CallerArn, profile.VerifyError)
+		}
+
+		output, err = runCommandOutput(t, "status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output, "PROFILE") || !strings.Contains(output, testRoles[0].RoleArn) {
+			t.Errorf("status table:\n%s", output)
+		}
+
+		output, err = runCommandOutput(t, "status", "--output", "yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output, "refreshToken:\n") || !strings.Contains(output, "    roleArn: "+testRoles[0].RoleArn+"\n") {
+			t.Errorf("status yaml:\n%s", output)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/topicuskeyhub/aws-keyhub/pkg/aws_keyhub"
+)
+
+func init() {
+	rootCmd.AddCommand(syncProfilesCmd)
+	syncProfilesCmd.Flags().StringVar(&syncRegion, "region", "eu-west-1", "region to configure for the profiles")
+	syncProfilesCmd.Flags().StringVar(&syncAwsOutput, "aws-output", "json", "AWS CLI output format to configure for the profiles (json, text, table or yaml)")
+	syncProfilesCmd.Flags().BoolVar(&syncCredentialProcess, "credential-process", false, "configure the profiles to retrieve credentials with `aws-keyhub credential-process`")
+	syncProfilesCmd.Flags().StringVar(&syncProfileTemplate, "profile-template", "", "template for the profile names, available fields: .AccountId, .RoleName, .RoleArn, .PrincipalArn and .Description (default \""+aws_keyhub.DefaultProfileTemplate+"\")")
+	syncProfilesCmd.Flags().BoolVar(&syncPrune, "prune", true, "remove profiles created by aws-keyhub for roles that are no longer available")
+}
+
+var syncProfilesCmd = &cobra.Command{
+	Use:   "sync-profiles",
+	Short: "create AWS profiles for all roles",
+	Long: `Creates or updates a profile in ~/.aws/config for every role you have access to. Profiles that
+were created by aws-keyhub for roles you no longer have access to are removed, other profiles are left alone.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Verbose {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return syncProfiles(cmd)
+	},
+}
+
+// SyncProfilesReport is the document sync-profiles writes with --output json or yaml.
+type SyncProfilesReport struct {
+	Context string   `json:"context"`
+	Written []string `json:"written"`
+	Removed []string `json:"removed"`
+}
+
+var syncRegion string
+var syncAwsOutput string
+var syncCredentialProcess bool
+var syncProfileTemplate string
+var syncPrune bool
+
+func syncProfiles(cmd *cobra.Command) error {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+	client, err := loadClient()
+	if err != nil {
+		return err
+	}
+	if err := aws_keyhub.CheckIfAwsConfigFileExists(); err != nil {
+		return err
+	}
+
+	samlAssertion, err := client.RetrieveSamlAssertion(cmd.Context())
+	if err != nil {
+		return err
+	}
+	sortedRolesAndPrincipals := aws_keyhub.SortedRolesAndPrincipals(samlAssertion.RolesAndPrincipals)
+	prune := syncPrune
+	if len(sortedRolesAndPrincipals) == 0 && prune {
+		logrus.Warnln("KeyHub did not return any roles, existing profiles are not removed.")
+		prune = false
+	}
+
+	profileNames, err := multipleRolesProfileNames(client, syncProfileTemplate, sortedRolesAndPrincipals)
+	if err != nil {
+		return err
+	}
+	var profiles []aws_keyhub.ConfigProfile
+	for _, roleAndPrincipal := range sortedRolesAndPrincipals {
+		profile := aws_keyhub.ConfigProfile{
+			Name:             profileNames[roleAndPrincipal.Role],
+			RoleAndPrincipal: roleAndPrincipal,
+			Region:           syncRegion,
+			Output:           syncAwsOutput,
+		}
+		if syncCredentialProcess {
+			profile.CredentialProcess = credentialProcessCommand(client, roleAndPrincipal.Role)
+		}
+		profiles = append(profiles, profile)
+	}
+
+	written, removed, err := client.SyncConfigProfiles(profiles, prune)
+	if err != nil {
+		return err
+	}
+	for _, roleAndPrincipal := range sortedRolesAndPrincipals {
+		if slices.Contains(written, profileNames[roleAndPrincipal.Role]) {
+			if err := client.StoreProfileForRole(roleAndPrincipal.Role, profileNames[roleAndPrincipal.Role]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, profileName := range removed {
+		logrus.Infof("Removed profile `%s`.", profileName)
+	}
+	logrus.Infof("Synchronized %d profiles: %s", len(written), strings.Join(written, ", "))
+
+	report := SyncProfilesReport{Context: client.Context(), Written: written, Removed: removed}
+	if report.Written == nil {
+		report.Written = []string{}
+	}
+	if report.Removed == nil {
+		report.Removed = []string{}
+	}
+	return writeOutput(cmd.OutOrStdout(), report, nil)
+}
+
+// credentialProcessCommand returns the credential_process setting that calls this aws-keyhub executable for the role in
+// the context of the client.
+func credentialProcessCommand(client *aws_keyhub.Client, roleArn string) string {
+	executable, err := os.Executable()
+	if err != nil {
+		logrus.Debugln("Unable to determine aws-keyhub executable, relying on PATH:", err)
+		executable = "aws-keyhub"
+	}
+	if strings.ContainsAny(executable, " \t") {
+		executable = `"` + executable + `"`
+	}
+	// The context is always given, the profile should not follow a later `context use`.
+	return fmt.Sprintf("%s credential-process --role-arn %s --context %s", executable, roleArn, client.Context())
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/synctest"
+)
+
+func TestSyncProfiles(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		setupFakes(t, testRoles...)
+
+		output, err := runCommandOutput(t, "sync-profiles", "--aws-output", "table", "--output", "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report SyncProfilesReport
+		if err := json.Unmarshal([]byte(output), &report); err != nil {
+			t.Fatalf("%v in:\n%s", err, output)
+		}
+		if len(report.Written) != len(testRoles) || len(report.Removed) != 0 {
+			t.Errorf("report %+v, want a profile for every role", report)
+		}
+		awsConfig, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".aws", "config"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(string(awsConfig), "= table\n") != len(testRoles) {
+			t.Errorf("profiles do not have the AWS CLI output of --aws-output:\n%s", awsConfig)
+		}
+	})
+}
@@ -8,10 +8,11 @@ import (
 var GitTag = "development"
 var GitHash = "unknown"
 var GoVersion = "unknown"
+var version string
 
 func init() {
 	rootCmd.AddCommand(versionCommand)
-	loginCmd.Flags().StringVar(&roleArn, "version", "", "show aws-keyhub version")
+	loginCmd.Flags().StringVar(&version, "version", "", "show aws-keyhub version")
 }
 
 var versionCommand = &cobra.Command{
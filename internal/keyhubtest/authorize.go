@@ -0,0 +1,71 @@
+package keyhubtest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// authorizationCode is an authorization code issued by the authorize endpoint, with the PKCE code challenge and
+// redirect URI the token request has to match.
+type authorizationCode struct {
+	codeChallenge string
+	redirectUri   string
+}
+
+// Authorize handles the authorize URL like KeyHub does after the user authorized aws-keyhub, and returns the URL the
+// browser is redirected to.
+func (keyhub *KeyHub) Authorize(authorizeUrl string) (string, error) {
+	keyhub.mu.Lock()
+	defer keyhub.mu.Unlock()
+	keyhub.requests["authorize"]++
+	parsed, err := url.Parse(authorizeUrl)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(authorizeUrl, keyhub.URL+"/login/oauth2/authorize?") {
+		return "", fmt.Errorf("not a KeyHub authorize url: %s", authorizeUrl)
+	}
+	query := parsed.Query()
+	redirectUri, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectUri.Scheme != "http" || redirectUri.Hostname() != "127.0.0.1" {
+		return "", fmt.Errorf("redirect uri %q is not a loopback redirect uri", query.Get("redirect_uri"))
+	}
+	if query.Get("client_id") != ClientId || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		return "", errors.New("invalid authorize request " + authorizeUrl)
+	}
+
+	response := url.Values{"state": {query.Get("state")}}
+	if keyhub.AuthorizeError != "" {
+		response.Set("error", keyhub.AuthorizeError)
+		response.Set("error_description", "keyhubtest: "+keyhub.AuthorizeError)
+	} else {
+		keyhub.issued++
+		code := fmt.Sprintf("authorization-code-%d", keyhub.issued)
+		keyhub.codes[code] = authorizationCode{codeChallenge: query.Get("code_challenge"), redirectUri: redirectUri.String()}
+		response.Set("code", code)
+	}
+	redirectUri.RawQuery = response.Encode()
+	return redirectUri.String(), nil
+}
+
+// Browser returns a function to open URLs with, that behaves like a browser in which the user authorizes aws-keyhub:
+// the authorize URL is handled by Authorize and the redirect is followed.
+func (keyhub *KeyHub) Browser() func(url string) error {
+	return func(authorizeUrl string) error {
+		redirectUrl, err := keyhub.Authorize(authorizeUrl)
+		if err != nil {
+			return err
+		}
+		go func() {
+			resp, err := http.Get(redirectUrl)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+}
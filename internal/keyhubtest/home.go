@@ -0,0 +1,33 @@
+package keyhubtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// awsEnvironmentVariables would make the AWS SDK ignore the profiles in the test home directory.
+var awsEnvironmentVariables = []string{
+	"AWS_PROFILE", "AWS_DEFAULT_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+	"AWS_CONFIG_FILE", "AWS_SHARED_CREDENTIALS_FILE", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ENDPOINT_URL",
+	"AWS_ENDPOINT_URL_STS", "AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+}
+
+// SetupHome points the home directory to an empty temporary directory with an AWS CLI configuration file, and clears
+// the AWS environment variables for the duration of the test. It returns the home directory.
+func SetupHome(t testing.TB) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	for _, name := range awsEnvironmentVariables {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".aws"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".aws", "config"), []byte("[default]\nregion = eu-west-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return home
+}
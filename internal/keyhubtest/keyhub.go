@@ -0,0 +1,316 @@
+// Package keyhubtest provides an in-process fake KeyHub and fake AWS STS for tests.
+package keyhubtest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ClientId        = "00000000-0000-0000-0000-000000000000"
+	AwsSamlClientId = "urn:tkh-clientid:urn:amazon:webservices"
+	UserCode        = "ABCD-EFGH"
+
+	grantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeRefreshToken      = "refresh_token"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken       = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeSaml2             = "urn:ietf:params:oauth:token-type:saml2"
+
+	roleAttribute   = "https://aws.amazon.com/SAML/Attributes/Role"
+	groupsAttribute = "https://github.com/topicuskeyhub/aws-keyhub/groups"
+)
+
+// Role is an AWS role the fake KeyHub grants access to in its SAML assertions.
+type Role struct {
+	RoleArn      string
+	PrincipalArn string
+	Description  string // Sent in the groups attribute when not empty.
+}
+
+// KeyHub is a fake KeyHub implementing the OAuth2 device authorization, authorization code, refresh token and SAML
+// token exchange grants used by aws-keyhub. Requests are handled in-process by the HTTP client returned by Client.
+type KeyHub struct {
+	URL   string
+	Roles []Role
+	// Interval is the polling interval in seconds returned by the device authorization endpoint.
+	Interval int
+	// ExpiresIn is the lifetime in seconds of the device code, 600 when zero.
+	ExpiresIn int
+	// DeviceCodeErrors are the OAuth2 error codes returned, one per poll, before the device code is exchanged for
+	// tokens, e.g. authorization_pending, slow_down, expired_token or access_denied. The device code is no longer
+	// accepted after an error other than authorization_pending or slow_down.
+	DeviceCodeErrors []string
+	// RefreshTokenLifetime is the lifetime of the issued refresh tokens, one hour when zero.
+	RefreshTokenLifetime time.Duration
+	// AuthorizeError is the OAuth2 error code the authorize endpoint redirects with instead of an authorization code,
+	// e.g. access_denied.
+	AuthorizeError string
+	// RefreshTokenStatus makes the refresh token grant respond with the HTTP status, e.g. 503 Service Unavailable,
+	// without using the refresh token.
+	RefreshTokenStatus int
+	// RevocationUnsupported makes the revocation endpoint respond with 404 Not Found, like a KeyHub without one.
+	RevocationUnsupported bool
+
+	mu            sync.Mutex
+	requests      map[string]int
+	deviceCodes   map[string]bool
+	accessTokens  map[string]bool
+	refreshTokens map[string]bool
+	codes         map[string]authorizationCode
+	issued        int
+	handler       http.Handler
+}
+
+// NewKeyHub creates a fake KeyHub that grants access to the roles.
+func NewKeyHub(roles ...Role) *KeyHub {
+	keyhub := &KeyHub{
+		URL:           "https://keyhub.test",
+		Roles:         roles,
+		requests:      make(map[string]int),
+		deviceCodes:   make(map[string]bool),
+		accessTokens:  make(map[string]bool),
+		refreshTokens: make(map[string]bool),
+		codes:         make(map[string]authorizationCode),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login/oauth2/authorizedevice", keyhub.handleAuthorizeDevice)
+	mux.HandleFunc("POST /login/oauth2/token", keyhub.handleToken)
+	mux.HandleFunc("POST /login/oauth2/revoke", keyhub.handleRevoke)
+	keyhub.handler = mux
+	return keyhub
+}
+
+// Client returns an HTTP client that sends its requests to the fake, whatever the URL.
+func (keyhub *KeyHub) Client() *http.Client {
+	return &http.Client{Transport: handlerTransport{keyhub.handler}}
+}
+
+// Requests returns the number of requests for the grant type, "authorizedevice" for device authorization requests or
+// "revoke" for revocation requests.
+func (keyhub *KeyHub) Requests(grantType string) int {
+	keyhub.mu.Lock()
+	defer keyhub.mu.Unlock()
+	return keyhub.requests[grantType]
+}
+
+// IssueRefreshToken returns a valid refresh token, as if the user authorized aws-keyhub before.
+func (keyhub *KeyHub) IssueRefreshToken() string {
+	keyhub.mu.Lock()
+	defer keyhub.mu.Unlock()
+	return keyhub.issueTokens()["refresh_token"].(string)
+}
+
+// RevokeRefreshTokens makes all issued refresh tokens invalid.
+func (keyhub *KeyHub) RevokeRefreshTokens() {
+	keyhub.mu.Lock()
+	defer keyhub.mu.Unlock()
+	clear(keyhub.refreshTokens)
+}
+
+// IsRefreshTokenValid reports whether the refresh token was issued and is not used or revoked yet.
+func (keyhub *KeyHub) IsRefreshTokenValid(refreshToken string) bool {
+	keyhub.mu.Lock()
+	defer keyhub.mu.Unlock()
+	return keyhub.refreshTokens[refreshToken]
+}
+
+func (keyhub *KeyHub) handleAuthorizeDevice(w http.ResponseWriter, r *http.Request) {
+	keyhub.mu.Lock()
+	defer keyhub.mu.Unlock()
+	keyhub.requests["authorizedevice"]++
+	if r.PostFormValue("client_id") != ClientId {
+		writeOAuth2Error(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostFormValue("resource") != AwsSamlClientId {
+		writeOAuth2Error(w, http.StatusBadRequest, "invalid_target")
+		return
+	}
+	keyhub.issued++
+	deviceCode := fmt.Sprintf("device-code-%d", keyhub.issued)
+	keyhub.deviceCodes[deviceCode] = true
+	expiresIn := keyhub.ExpiresIn
+	if expiresIn == 0 {
+		expiresIn = 600
+	}
+	writeJson(w, map[string]any{
+		"device_code":               deviceCode,
+		"user_code":                 UserCode,
+		"verification_uri":          keyhub.URL + "/device",
+		"verification_uri_complete": keyhub.URL + "/device?user_code=" + UserCode,
+		"interval":                  keyhub.Interval,
+		"expires_in":                expiresIn,
+	})
+}
+
+func (keyhub *KeyHub) handleToken(w http.ResponseWriter, r *http.Request) {
+	keyhub.mu.Lock()
+	defer keyhub.mu.Unlock()
+	grantType := r.PostFormValue("grant_type")
+	keyhub.requests[grantType]++
+	if r.PostFormValue("client_id") != ClientId {
+		writeOAuth2Error(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	switch grantType {
+	case grantTypeDeviceCode:
+		deviceCode := r.PostFormValue("device_code")
+		if !keyhub.deviceCodes[deviceCode] {
+			writeOAuth2Error(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		if len(keyhub.DeviceCodeErrors) > 0 {
+			errorCode := keyhub.DeviceCodeErrors[0]
+			keyhub.DeviceCodeErrors = keyhub.DeviceCodeErrors[1:]
+			if errorCode != "authorization_pending" && errorCode != "slow_down" {
+				delete(keyhub.deviceCodes, deviceCode)
+			}
+			writeOAuth2Error(w, http.StatusBadRequest, errorCode)
+			return
+		}
+		delete(keyhub.deviceCodes, deviceCode)
+		writeJson(w, keyhub.issueTokens())
+	case grantTypeAuthorizationCode:
+		code, exists := keyhub.codes[r.PostFormValue("code")]
+		delete(keyhub.codes, r.PostFormValue("code"))
+		verifierHash := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !exists || code.redirectUri != r.PostFormValue("redirect_uri") ||
+			code.codeChallenge != base64.RawURLEncoding.EncodeToString(verifierHash[:]) {
+			writeOAuth2Error(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		writeJson(w, keyhub.issueTokens())
+	case grantTypeRefreshToken:
+		if keyhub.RefreshTokenStatus != 0 {
+			http.Error(w, http.StatusText(keyhub.RefreshTokenStatus), keyhub.RefreshTokenStatus)
+			return
+		}
+		refreshToken := r.PostFormValue("refresh_token")
+		if !keyhub.refreshTokens[refreshToken] {
+			writeOAuth2Error(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		delete(keyhub.refreshTokens, refreshToken)
+		writeJson(w, keyhub.issueTokens())
+	case grantTypeTokenExchange:
+		if !keyhub.accessTokens[r.PostFormValue("subject_token")] || r.PostFormValue("subject_token_type") != tokenTypeAccessToken {
+			writeOAuth2Error(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		if r.PostFormValue("requested_token_type") != tokenTypeSaml2 || r.PostFormValue("resource") != AwsSamlClientId {
+			writeOAuth2Error(w, http.StatusBadRequest, "invalid_target")
+			return
+		}
+		writeJson(w, map[string]any{
+			"access_token":      SamlResponse(keyhub.Roles),
+			"issued_token_type": tokenTypeSaml2,
+			"token_type":        "N_A",
+			"expires_in":        300,
+		})
+	default:
+		writeOAuth2Error(w, http.StatusBadRequest, "unsupported_grant_type")
+	}
+}
+
+// handleRevoke implements token revocation as described in RFC 7009, unknown tokens are not an error.
+func (keyhub *KeyHub) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	keyhub.mu.Lock()
+	defer keyhub.mu.Unlock()
+	keyhub.requests["revoke"]++
+	if keyhub.RevocationUnsupported {
+		http.NotFound(w, r)
+		return
+	}
+	if r.PostFormValue("client_id") != ClientId {
+		writeOAuth2Error(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	delete(keyhub.refreshTokens, r.PostFormValue("token"))
+	delete(keyhub.accessTokens, r.PostFormValue("token"))
+	w.WriteHeader(http.StatusOK)
+}
+
+// issueTokens returns a token response with a new access token and refresh token, the refresh token is a JWT with
+// an exp claim like the ones issued by KeyHub.
+func (keyhub *KeyHub) issueTokens() map[string]any {
+	keyhub.issued++
+	accessToken := fmt.Sprintf("access-token-%d", keyhub.issued)
+	lifetime := keyhub.RefreshTokenLifetime
+	if lifetime == 0 {
+		lifetime = time.Hour
+	}
+	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti": fmt.Sprintf("refresh-token-%d", keyhub.issued),
+		"exp": time.Now().Add(lifetime).Unix(),
+	}).SignedString([]byte("keyhubtest"))
+	if err != nil {
+		panic(err)
+	}
+	keyhub.accessTokens[accessToken] = true
+	keyhub.refreshTokens[refreshToken] = true
+	return map[string]any{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "bearer",
+		"scope":         "profile",
+		"expires_in":    300,
+	}
+}
+
+// SamlResponse returns a base64url encoded SAML Response with the role and groups attributes KeyHub sends to AWS.
+func SamlResponse(roles []Role) string {
+	var builder strings.Builder
+	builder.WriteString(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">`)
+	builder.WriteString(`<saml:Assertion><saml:AttributeStatement>`)
+	writeAttribute(&builder, roleAttribute, roles, func(role Role) string {
+		return role.RoleArn + "," + role.PrincipalArn
+	})
+	var described []Role
+	for _, role := range roles {
+		if role.Description != "" {
+			described = append(described, role)
+		}
+	}
+	writeAttribute(&builder, groupsAttribute, described, func(role Role) string {
+		value, _ := json.Marshal(map[string]string{"description": role.Description, "arn": role.RoleArn + "," + role.PrincipalArn})
+		return string(value)
+	})
+	builder.WriteString(`</saml:AttributeStatement></saml:Assertion></samlp:Response>`)
+	return base64.URLEncoding.EncodeToString([]byte(builder.String()))
+}
+
+func writeAttribute(builder *strings.Builder, name string, roles []Role, value func(Role) string) {
+	if len(roles) == 0 {
+		return
+	}
+	fmt.Fprintf(builder, `<saml:Attribute Name="%s">`, name)
+	for _, role := range roles {
+		builder.WriteString(`<saml:AttributeValue>`)
+		xml.EscapeText(builder, []byte(value(role)))
+		builder.WriteString(`</saml:AttributeValue>`)
+	}
+	builder.WriteString(`</saml:Attribute>`)
+}
+
+func writeOAuth2Error(w http.ResponseWriter, statusCode int, errorCode string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": errorCode, "error_description": "keyhubtest: " + errorCode})
+}
+
+func writeJson(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,130 @@
+package keyhubtest
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const stsNamespace = "https://sts.amazonaws.com/doc/2011-06-15/"
+
+var credentialScope = regexp.MustCompile(`Credential=([^/]+)/`)
+
+// Sts is a fake AWS STS implementing AssumeRoleWithSAML and GetCallerIdentity with the AWS query protocol. Only
+// SAML assertions that grant access to the role are accepted. Requests are handled in-process by the client returned
+// by Client.
+type Sts struct {
+	// DeniedRoles are refused even when the SAML assertion grants access to them, like a role whose trust policy
+	// does not trust the SAML provider.
+	DeniedRoles []string
+	// RevokedRoles are assumed, but GetCallerIdentity refuses their sessions, like sessions that were revoked right
+	// after they were issued.
+	RevokedRoles []string
+
+	mu           sync.Mutex
+	issued       int
+	sessions     map[string]string // Role ARN per access key ID.
+	assumedRoles []string
+}
+
+// NewSts creates a fake STS.
+func NewSts() *Sts {
+	return &Sts{sessions: make(map[string]string)}
+}
+
+// Client returns an STS client that sends its requests to the fake.
+func (fake *Sts) Client() *sts.Client {
+	return sts.New(sts.Options{
+		BaseEndpoint: aws.String("https://sts.test"),
+		Region:       "eu-west-1",
+		HTTPClient:   &http.Client{Transport: handlerTransport{http.HandlerFunc(fake.handle)}},
+	})
+}
+
+// AssumedRoles returns the role ARNs of the successful AssumeRoleWithSAML calls, in order.
+func (fake *Sts) AssumedRoles() []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return append([]string(nil), fake.assumedRoles...)
+}
+
+func (fake *Sts) handle(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	switch r.PostFormValue("Action") {
+	case "AssumeRoleWithSAML":
+		fake.assumeRoleWithSAML(w, r)
+	case "GetCallerIdentity":
+		fake.getCallerIdentity(w, r)
+	default:
+		writeStsError(w, http.StatusBadRequest, "InvalidAction", "unsupported action "+r.PostFormValue("Action"))
+	}
+}
+
+func (fake *Sts) assumeRoleWithSAML(w http.ResponseWriter, r *http.Request) {
+	roleArn := r.PostFormValue("RoleArn")
+	assertion, err := base64.URLEncoding.DecodeString(r.PostFormValue("SAMLAssertion"))
+	if err != nil {
+		writeStsError(w, http.StatusBadRequest, "InvalidIdentityToken", "invalid SAML assertion")
+		return
+	}
+	if !strings.Contains(string(assertion), ">"+roleArn+","+r.PostFormValue("PrincipalArn")+"<") || slices.Contains(fake.DeniedRoles, roleArn) {
+		writeStsError(w, http.StatusForbidden, "AccessDenied", "not authorized to perform sts:AssumeRoleWithSAML")
+		return
+	}
+	duration, err := strconv.Atoi(r.PostFormValue("DurationSeconds"))
+	if err != nil {
+		duration = 3600
+	}
+
+	fake.issued++
+	accessKeyId := fmt.Sprintf("ASIAKEYHUBTEST%06d", fake.issued)
+	fake.sessions[accessKeyId] = roleArn
+	fake.assumedRoles = append(fake.assumedRoles, roleArn)
+	writeStsResponse(w, "AssumeRoleWithSAML", fmt.Sprintf(
+		`<Credentials><AccessKeyId>%s</AccessKeyId><SecretAccessKey>secret-%[1]s</SecretAccessKey><SessionToken>token-%[1]s</SessionToken><Expiration>%s</Expiration></Credentials>`+
+			`<AssumedRoleUser><Arn>%s</Arn><AssumedRoleId>AROAKEYHUBTEST:user@example.com</AssumedRoleId></AssumedRoleUser>`,
+		accessKeyId, time.Now().Add(time.Duration(duration)*time.Second).UTC().Format(time.RFC3339), assumedRoleArn(roleArn)))
+}
+
+func (fake *Sts) getCallerIdentity(w http.ResponseWriter, r *http.Request) {
+	match := credentialScope.FindStringSubmatch(r.Header.Get("Authorization"))
+	if match == nil || fake.sessions[match[1]] == "" || slices.Contains(fake.RevokedRoles, fake.sessions[match[1]]) {
+		writeStsError(w, http.StatusForbidden, "InvalidClientTokenId", "the security token included in the request is invalid")
+		return
+	}
+	roleArn := fake.sessions[match[1]]
+	writeStsResponse(w, "GetCallerIdentity", fmt.Sprintf(
+		`<Arn>%s</Arn><UserId>AROAKEYHUBTEST:user@example.com</UserId><Account>%s</Account>`,
+		assumedRoleArn(roleArn), strings.Split(roleArn, ":")[4]))
+}
+
+// assumedRoleArn returns the STS ARN of a session of the IAM role.
+func assumedRoleArn(roleArn string) string {
+	return strings.Replace(strings.Replace(roleArn, ":iam:", ":sts:", 1), ":role/", ":assumed-role/", 1) + "/user@example.com"
+}
+
+func writeStsResponse(w http.ResponseWriter, action string, result string) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="%[2]s"><%[1]sResult>%[3]s</%[1]sResult><ResponseMetadata><RequestId>keyhubtest</RequestId></ResponseMetadata></%[1]sResponse>`,
+		action, stsNamespace, result)
+}
+
+func writeStsError(w http.ResponseWriter, statusCode int, code string, message string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(statusCode)
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(message))
+	fmt.Fprintf(w, `<ErrorResponse xmlns="%s"><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error><RequestId>keyhubtest</RequestId></ErrorResponse>`,
+		stsNamespace, code, escaped.String())
+}
@@ -0,0 +1,23 @@
+package keyhubtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+)
+
+// handlerTransport handles the requests in-process, so the fakes can be used without network connections, for
+// example in a testing/synctest bubble.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (transport handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	recorder := httptest.NewRecorder()
+	transport.handler.ServeHTTP(recorder, req)
+	response := recorder.Result()
+	response.Request = req
+	return response, nil
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"os"
+
 	"github.com/topicuskeyhub/aws-keyhub/cmd"
 )
 
 func main() {
-	cmd.Execute()
+	os.Exit(cmd.ExitCode(cmd.Execute()))
 }
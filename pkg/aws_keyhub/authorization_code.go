@@ -0,0 +1,158 @@
+package aws_keyhub
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AuthorizationCodeTimeout is how long aws-keyhub waits for the browser to return to the loopback redirect URI.
+const AuthorizationCodeTimeout = 5 * time.Minute
+
+// authorizationCodeCallbackPath is the path of the loopback redirect URI, it has to be allowed for the aws-keyhub
+// client in KeyHub as http://127.0.0.1/callback (any port, see RFC 8252 section 7.3).
+const authorizationCodeCallbackPath = "/callback"
+
+// errBrowserUnavailable is returned when the authorization code flow cannot be used because the browser could not be
+// opened, the device authorization flow is used instead.
+var errBrowserUnavailable = errors.New("browser unavailable")
+
+// authorizationCodeResult is what the browser passes to the loopback redirect URI.
+type authorizationCodeResult struct {
+	code string
+	err  error
+}
+
+// loginWithAuthorizationCode lets the user authorize aws-keyhub with the OAuth2 authorization code flow with PKCE
+// (RFC 7636). KeyHub redirects the browser to a temporary HTTP listener on the loopback interface, as described in
+// RFC 8252. It returns errBrowserUnavailable when there is no browser to open the KeyHub authorize page in.
+func (client *Client) loginWithAuthorizationCode(ctx context.Context) (TokenExchangeResponse, error) {
+	if client.openBrowser == nil {
+		return TokenExchangeResponse{}, errBrowserUnavailable
+	}
+	codeVerifier := randomUrlSafeString()
+	codeChallenge := sha256.Sum256([]byte(codeVerifier))
+	state := randomUrlSafeString()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return TokenExchangeResponse{}, fmt.Errorf("failed to start listener for the KeyHub redirect: %w", err)
+	}
+	redirectUri := "http://" + listener.Addr().String() + authorizationCodeCallbackPath
+
+	results := make(chan authorizationCodeResult, 1)
+	server := &http.Server{
+		Handler:           authorizationCodeCallbackHandler(state, results),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authorizeUrl := client.Config.Keyhub.Url + "/login/oauth2/authorize?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.Config.Keyhub.ClientId},
+		"redirect_uri":          {redirectUri},
+		"scope":                 {"profile"},
+		"resource":              {client.Config.Keyhub.AwsSamlClientId},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(codeChallenge[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+	logrus.Debugln("KeyHub authorize url:", authorizeUrl)
+	if err := client.openBrowser(authorizeUrl); err != nil {
+		logrus.Debugln("Failed to open the browser:", err)
+		return TokenExchangeResponse{}, errBrowserUnavailable
+	}
+	logrus.Infoln("If your browser did not open, please visit this url:", authorizeUrl)
+
+	timer := time.NewTimer(AuthorizationCodeTimeout)
+	defer timer.Stop()
+	var result authorizationCodeResult
+	select {
+	case <-ctx.Done():
+		return TokenExchangeResponse{}, fmt.Errorf("KeyHub login aborted: %w", ctx.Err())
+	case <-timer.C:
+		return TokenExchangeResponse{}, ErrAuthorizationTimeout
+	case result = <-results:
+	}
+	if result.err != nil {
+		return TokenExchangeResponse{}, result.err
+	}
+	return client.exchangeAuthorizationCode(ctx, result.code, redirectUri, codeVerifier)
+}
+
+// authorizationCodeCallbackHandler handles the redirect of the browser after the user authorized aws-keyhub, and
+// passes the authorization code or error to results once.
+func authorizationCodeCallbackHandler(state string, results chan<- authorizationCodeResult) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+authorizationCodeCallbackPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("state") != state {
+			http.Error(w, "Invalid state, please start the login again.", http.StatusBadRequest)
+			return
+		}
+		var result authorizationCodeResult
+		switch {
+		case query.Get("error") == "access_denied":
+			result.err = fmt.Errorf("%w: %w", ErrAuthorizationDenied, &KeyhubError{ErrorCode: "access_denied", ErrorDescription: query.Get("error_description")})
+		case query.Get("error") != "":
+			result.err = &KeyhubError{ErrorCode: query.Get("error"), ErrorDescription: query.Get("error_description")}
+		default:
+			result.code = query.Get("code")
+		}
+		select {
+		case results <- result:
+		default:
+			http.Error(w, "aws-keyhub already received the KeyHub response.", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if result.err != nil {
+			fmt.Fprintln(w, "aws-keyhub was not authorized, you can close this window.")
+		} else {
+			fmt.Fprintln(w, "aws-keyhub is authorized, you can close this window.")
+		}
+	})
+	return mux
+}
+
+func (client *Client) exchangeAuthorizationCode(ctx context.Context, code string, redirectUri string, codeVerifier string) (TokenExchangeResponse, error) {
+	data := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectUri},
+		"client_id":     {client.Config.Keyhub.ClientId},
+		"code_verifier": {codeVerifier},
+	}
+	resp, err := client.submitTokenExchange(ctx, data)
+	if err != nil {
+		return TokenExchangeResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		logrus.Infoln("KeyHub login using authorization code successful.")
+		return client.handleTokenExchangeSuccessResponse(resp, true)
+	case 400:
+		return TokenExchangeResponse{}, handleErrorResponse(resp)
+	default:
+		return TokenExchangeResponse{}, handleUnexpectedResponseCodeResponse(resp)
+	}
+}
+
+// randomUrlSafeString returns 256 random bits as 43 URL safe characters, long enough for a PKCE code verifier.
+func randomUrlSafeString() string {
+	random := make([]byte, 32)
+	rand.Read(random) // Never returns an error, it crashes the program instead.
+	return base64.RawURLEncoding.EncodeToString(random)
+}
@@ -0,0 +1,97 @@
+package aws_keyhub
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/synctest"
+
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+)
+
+// newAuthorizationCodeTestClient creates a test client configured for the authorization code grant, the KeyHub
+// authorize page is opened in a browser in which the user authorizes aws-keyhub.
+func newAuthorizationCodeTestClient(t *testing.T, keyhub *keyhubtest.KeyHub) *Client {
+	client := newTestClient(t, keyhub, nil)
+	client.Config.Keyhub.GrantType = GrantTypeAuthorizationCode
+	WithBrowser(keyhub.Browser())(client)
+	return client
+}
+
+func TestDoLoginAuthorizationCode(t *testing.T) {
+	keyhub := keyhubtest.NewKeyHub(testRoles...)
+	client := newAuthorizationCodeTestClient(t, keyhub)
+
+	loginResponse, err := client.DoLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ExchangeForSamlAssertion(context.Background(), loginResponse); err != nil {
+		t.Fatal(err)
+	}
+	if codes := keyhub.Requests("authorization_code"); codes != 1 {
+		t.Errorf("exchanged %d authorization codes, want 1", codes)
+	}
+	if authorizations := keyhub.Requests("authorizedevice"); authorizations != 0 {
+		t.Errorf("authorized device %d times, want 0", authorizations)
+	}
+	if refreshTokenFile, _ := client.readRefreshToken(); refreshTokenFile == nil {
+		t.Error("refresh token not stored")
+	}
+}
+
+func TestDoLoginAuthorizationCodeDenied(t *testing.T) {
+	keyhub := keyhubtest.NewKeyHub(testRoles...)
+	keyhub.AuthorizeError = "access_denied"
+	client := newAuthorizationCodeTestClient(t, keyhub)
+
+	_, err := client.DoLogin(context.Background())
+	if !errors.Is(err, ErrAuthorizationDenied) {
+		t.Errorf("got %v, want ErrAuthorizationDenied", err)
+	}
+	if codes := keyhub.Requests("authorization_code"); codes != 0 {
+		t.Errorf("exchanged %d authorization codes, want 0", codes)
+	}
+}
+
+func TestDoLoginAuthorizationCodeFallsBackToDeviceFlow(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		client := newAuthorizationCodeTestClient(t, keyhub)
+		WithoutBrowser()(client)
+		WithDeviceCodeOutput(io.Discard)(client)
+
+		if _, err := client.DoLogin(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if authorizations := keyhub.Requests("authorizedevice"); authorizations != 1 {
+			t.Errorf("authorized device %d times, want 1", authorizations)
+		}
+		if authorizations := keyhub.Requests("authorize"); authorizations != 0 {
+			t.Errorf("opened the authorize page %d times, want 0", authorizations)
+		}
+	})
+}
+
+func TestAuthorizationCodeCallbackHandler(t *testing.T) {
+	results := make(chan authorizationCodeResult, 1)
+	handler := authorizationCodeCallbackHandler("expected-state", results)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/callback?code=forged&state=other-state", nil))
+	if recorder.Code != http.StatusBadRequest || len(results) != 0 {
+		t.Errorf("accepted a redirect with another state, status %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/callback?code=the-code&state=expected-state", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status %d for a valid redirect", recorder.Code)
+	}
+	if result := <-results; result.code != "the-code" || result.err != nil {
+		t.Errorf("got %+v, want the authorization code", result)
+	}
+}
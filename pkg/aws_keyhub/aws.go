@@ -2,7 +2,10 @@ package aws_keyhub
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -10,46 +13,83 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/aws-sdk-go-v2/service/sts/types"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/ini.v1"
 )
 
-func StsAssumeRoleWithSAML(context context.Context, principalArn string, roleArn string, samlAssertion string) *sts.AssumeRoleWithSAMLOutput {
-	awsKeyHubConfig := getAwsKeyHubConfig()
+func (client *Client) StsAssumeRoleWithSAML(context context.Context, principalArn string, roleArn string, samlAssertion string) (*sts.AssumeRoleWithSAMLOutput, error) {
 	input := &sts.AssumeRoleWithSAMLInput{
-		DurationSeconds: aws.Int32(awsKeyHubConfig.Aws.AssumeDuration),
+		DurationSeconds: aws.Int32(client.Config.Aws.AssumeDuration),
 		PrincipalArn:    aws.String(principalArn),
 		RoleArn:         aws.String(roleArn),
 		SAMLAssertion:   aws.String(samlAssertion),
 	}
 
-	cfg, err := config.LoadDefaultConfig(context)
+	svc, err := client.getStsClient(context)
 	if err != nil {
-		logrus.Fatal("Failed to configure AWS SDK for STS call, please check your AWS CLI configuration: ", err)
+		return nil, err
 	}
-
-	svc := sts.NewFromConfig(cfg)
 	result, err := svc.AssumeRoleWithSAML(context, input)
 
 	if err != nil {
-		logrus.Fatal("AWS STS AssumeRoleWithSAML failed:", err)
+		return nil, &StsError{Operation: "AssumeRoleWithSAML", RoleArn: roleArn, Err: err}
 	}
 	logrus.Debugln("AWS STS AssumeRoleWithSAML result:", result)
-	return result
+	return result, nil
+}
+
+// stsConcurrency is the number of roles StsAssumeRolesWithSAML assumes at the same time, to stay clear of STS throttling.
+const stsConcurrency = 4
+
+// StsAssumeRolesWithSAML assumes all roles with the same SAML assertion, a few at a time. The outputs are in the order
+// of the roles. When roles fail, the outputs of the roles that succeeded are returned together with the joined
+// StsError of every failed role, whose output is nil.
+func (client *Client) StsAssumeRolesWithSAML(context context.Context, rolesAndPrincipals []RolesAndPrincipals, samlAssertion string) ([]*sts.AssumeRoleWithSAMLOutput, error) {
+	results := make([]*sts.AssumeRoleWithSAMLOutput, len(rolesAndPrincipals))
+	errs := make([]error, len(rolesAndPrincipals))
+	var group errgroup.Group
+	group.SetLimit(stsConcurrency)
+	for i, roleAndPrincipal := range rolesAndPrincipals {
+		group.Go(func() error {
+			results[i], errs[i] = client.StsAssumeRoleWithSAML(context, roleAndPrincipal.Principal, roleAndPrincipal.Role, samlAssertion)
+			// A failed role does not stop the others.
+			return nil
+		})
+	}
+	group.Wait()
+	return results, errors.Join(errs...)
 }
 
-func VerifyIfLoginWasSuccessful(context context.Context, profile string, roleArn string) {
+// VerifyIfLoginWasSuccessful calls STS GetCallerIdentity with the credentials and region of the profile, as read from
+// the files aws-keyhub writes to.
+func (client *Client) VerifyIfLoginWasSuccessful(context context.Context, profile string, roleArn string) (*sts.GetCallerIdentityOutput, error) {
+	credentialFilePath, err := getCredentialFilePath()
+	if err != nil {
+		return nil, err
+	}
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return nil, err
+	}
 	// There is no fallback on default profile, this might be a bug in aws go v2 sdk. For now set default region to avoid errors.
-	cfg, err := config.LoadDefaultConfig(context, config.WithDefaultRegion("eu-west-1"), config.WithSharedConfigProfile(profile))
+	cfg, err := config.LoadDefaultConfig(context, config.WithDefaultRegion("eu-west-1"), config.WithSharedConfigProfile(profile),
+		config.WithSharedCredentialsFiles([]string{credentialFilePath}), config.WithSharedConfigFiles([]string{configFilePath}))
 
 	if err != nil {
-		logrus.Fatal("Failed to configure AWS SDK for STS call, please check your AWS CLI configuration: ", err)
+		return nil, fmt.Errorf("failed to configure AWS SDK for STS call, please check your AWS CLI configuration: %w", err)
+	}
+	svc, err := client.getStsClient(context)
+	if err != nil {
+		return nil, err
 	}
-	svc := sts.NewFromConfig(cfg)
 
 	input := &sts.GetCallerIdentityInput{}
-	result, err := svc.GetCallerIdentity(context, input)
+	result, err := svc.GetCallerIdentity(context, input, func(options *sts.Options) {
+		options.Region = cfg.Region
+		options.Credentials = cfg.Credentials
+	})
 	if err != nil {
-		logrus.Fatal("Failed to get caller identity:", err)
+		return nil, &StsError{Operation: "GetCallerIdentity", RoleArn: roleArn, Err: err}
 	}
 	logrus.Debugln("AWS STS GetCallerIdentity result:", result)
 
@@ -61,70 +101,154 @@ func VerifyIfLoginWasSuccessful(context context.Context, profile string, roleArn
 
 	// AssumedRole should contain the RoleArn since the AssumedRole also contains the username a exact match won't work
 	if !strings.Contains(calculatedAssumedRoleArn, roleArn) {
-		logrus.Fatal("Login failed, role arn did not match with expected arn.")
+		return result, fmt.Errorf("login failed, role arn %s did not match with expected arn %s", retrievedStsRoleArn, roleArn)
 	}
+	return result, nil
 }
 
-func CheckIfAwsConfigFileExists() {
-	if _, err := os.Stat(getConfigFilePath()); os.IsNotExist(err) {
-		logrus.Fatal("It looks like you have no AWS configuration file. Please run `aws configure` first. You can leave the access key fields empty.")
+func CheckIfAwsConfigFileExists() error {
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(configFilePath); os.IsNotExist(err) {
+		return &AwsConfigNotFoundError{Path: configFilePath}
 	}
 	logrus.Debugln("AWS configuration file exists.")
+	return nil
 }
 
-func WriteCredentialFile(profile string, credentials *types.Credentials) {
+func WriteCredentialFile(profile string, credentials *types.Credentials) error {
 	accessKeyId := *credentials.AccessKeyId
 	secretAccessKey := *credentials.SecretAccessKey
 	sessionToken := *credentials.SessionToken
 
-	credentialFilePath := getCredentialFilePath()
-	createCredentialsFileIfNotExists(credentialFilePath)
+	credentialFilePath, err := getCredentialFilePath()
+	if err != nil {
+		return err
+	}
+	if err := createCredentialsFileIfNotExists(credentialFilePath); err != nil {
+		return err
+	}
 
 	cfg, err := ini.Load(credentialFilePath)
 	if err != nil {
-		logrus.Fatal("Failed to read credentials file:", err)
+		return fmt.Errorf("failed to read credentials file: %w", err)
 	}
 
 	sec := cfg.Section(profile) // Auto-create if not exists
-	createNewKeyInSection(sec, "aws_access_key_id", accessKeyId)
-	createNewKeyInSection(sec, "aws_secret_access_key", secretAccessKey)
-	createNewKeyInSection(sec, "aws_session_token", sessionToken)
+	for _, keyValue := range [][2]string{
+		{"aws_access_key_id", accessKeyId},
+		{"aws_secret_access_key", secretAccessKey},
+		{"aws_session_token", sessionToken},
+	} {
+		if err := createNewKeyInSection(sec, keyValue[0], keyValue[1]); err != nil {
+			return err
+		}
+	}
 
 	err = cfg.SaveTo(credentialFilePath)
 	if err != nil {
-		logrus.Fatal("Credentials could not be saved:  ", err)
+		return fmt.Errorf("credentials could not be saved: %w", err)
 	}
 	logrus.Debugf("Credentials saved to '%s' under profile section: [%s]", credentialFilePath, profile)
+	return nil
+}
+
+// credentialFileKeys are the keys WriteCredentialFile writes to a profile in the credentials file.
+var credentialFileKeys = []string{"aws_access_key_id", "aws_secret_access_key", "aws_session_token"}
+
+// RemoveCredentialFileProfile removes the keys written by WriteCredentialFile from the profile in the credentials
+// file. Other keys of the profile are kept, the profile itself is removed when no keys are left. It is not an error
+// when the profile does not exist.
+func RemoveCredentialFileProfile(profile string) error {
+	credentialFilePath, err := getCredentialFilePath()
+	if err != nil {
+		return err
+	}
+	cfg, err := ini.Load(credentialFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	sec, err := cfg.GetSection(profile)
+	if err != nil {
+		return nil
+	}
+	for _, key := range credentialFileKeys {
+		sec.DeleteKey(key)
+	}
+	if len(sec.Keys()) == 0 {
+		cfg.DeleteSection(profile)
+	}
+	if err := cfg.SaveTo(credentialFilePath); err != nil {
+		return fmt.Errorf("credentials could not be saved: %w", err)
+	}
+	logrus.Debugf("Removed credentials of profile [%s] from '%s'", profile, credentialFilePath)
+	return nil
 }
 
-func createNewKeyInSection(sec *ini.Section, key string, value string) {
+// ReadCredentialFileAccessKeyId returns the access key ID of the profile in the credentials file, or an empty string
+// when the profile does not exist.
+func ReadCredentialFileAccessKeyId(profile string) (string, error) {
+	credentialFilePath, err := getCredentialFilePath()
+	if err != nil {
+		return "", err
+	}
+	cfg, err := ini.Load(credentialFilePath)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	sec, err := cfg.GetSection(profile)
+	if err != nil {
+		return "", nil
+	}
+	return sec.Key("aws_access_key_id").String(), nil
+}
+
+func createNewKeyInSection(sec *ini.Section, key string, value string) error {
 	_, err := sec.NewKey(key, value)
 	if err != nil {
-		logrus.Fatal("Unable to create new section in config file.", err)
+		return fmt.Errorf("unable to create key %s in section [%s]: %w", key, sec.Name(), err)
 	}
+	return nil
 }
 
-func createCredentialsFileIfNotExists(credentialFilePath string) {
+func createCredentialsFileIfNotExists(credentialFilePath string) error {
 	file, err := os.OpenFile(credentialFilePath, os.O_RDONLY|os.O_CREATE, 0600)
 	if err != nil {
-		logrus.Fatal("Unable to create AWS CLI credentials file.", err)
+		return fmt.Errorf("unable to create AWS CLI credentials file: %w", err)
 	}
-	file.Close()
+	return file.Close()
 }
 
-func getAwsCliPath() string {
-	awsCliPath := getUserHomeDir() + string(os.PathSeparator) + ".aws" + string(os.PathSeparator)
-	return awsCliPath
+func getAwsCliPath() (string, error) {
+	userHomeDir, err := getUserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userHomeDir, ".aws"), nil
 }
 
-func getCredentialFilePath() string {
-	credentialFilePath := getAwsCliPath() + "credentials"
+func getCredentialFilePath() (string, error) {
+	awsCliPath, err := getAwsCliPath()
+	if err != nil {
+		return "", err
+	}
+	credentialFilePath := filepath.Join(awsCliPath, "credentials")
 	logrus.Debugln("Calculated AWS CLI credentials file path:", credentialFilePath)
-	return credentialFilePath
+	return credentialFilePath, nil
 }
 
-func getConfigFilePath() string {
-	configFilePath := getAwsCliPath() + "config"
+func getConfigFilePath() (string, error) {
+	awsCliPath, err := getAwsCliPath()
+	if err != nil {
+		return "", err
+	}
+	configFilePath := filepath.Join(awsCliPath, "config")
 	logrus.Debugln("Calculated AWS CLI config file path:", configFilePath)
-	return configFilePath
+	return configFilePath, nil
 }
@@ -0,0 +1,163 @@
+package aws_keyhub
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/ini.v1"
+)
+
+// Profiles in the AWS config file that are managed by aws-keyhub are marked with the context they belong to.
+const ManagedProfileContextKey = "aws_keyhub_context"
+const ManagedProfileRoleArnKey = "aws_keyhub_role_arn"
+
+type ConfigProfile struct {
+	Name              string
+	RoleAndPrincipal  RolesAndPrincipals
+	Region            string
+	Output            string
+	CredentialProcess string
+}
+
+// SyncConfigProfiles writes the profiles to the AWS config file. When prune is set, the profiles managed for the context
+// of the client that are not in the given profiles are removed. Profiles that are not managed by aws-keyhub are never touched.
+// The names of the written and removed profiles are returned.
+func (client *Client) SyncConfigProfiles(profiles []ConfigProfile, prune bool) (written []string, removed []string, err error) {
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := ini.Load(configFilePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read AWS config file: %w", err)
+	}
+
+	context := client.contextName
+	syncedSections := make(map[string]bool)
+	for _, profile := range profiles {
+		sectionName := configSectionName(profile.Name)
+		if existing, err := cfg.GetSection(sectionName); err == nil && !isManagedBy(existing, context) {
+			logrus.Warnf("Skipping profile `%s`, it already exists in the AWS config file and is not managed by aws-keyhub context `%s`.", profile.Name, context)
+			continue
+		}
+
+		sec := cfg.Section(sectionName) // Auto-create if not exists
+		for _, keyValue := range [][2]string{
+			{ManagedProfileContextKey, context},
+			{ManagedProfileRoleArnKey, profile.RoleAndPrincipal.Role},
+			{"region", profile.Region},
+			{"output", profile.Output},
+			{"credential_process", profile.CredentialProcess},
+		} {
+			if err := setOrDeleteKeyInSection(sec, keyValue[0], keyValue[1]); err != nil {
+				return nil, nil, err
+			}
+		}
+		syncedSections[sectionName] = true
+		written = append(written, profile.Name)
+	}
+
+	if prune {
+		for _, sec := range cfg.Sections() {
+			if isManagedBy(sec, context) && !syncedSections[sec.Name()] {
+				cfg.DeleteSection(sec.Name())
+				removed = append(removed, profileNameFromConfigSection(sec.Name()))
+			}
+		}
+	}
+
+	err = cfg.SaveTo(configFilePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("AWS config file could not be saved: %w", err)
+	}
+	logrus.Debugf("Synchronized %d profiles to '%s', removed %d stale profiles", len(written), configFilePath, len(removed))
+	return written, removed, nil
+}
+
+func isManagedBy(sec *ini.Section, context string) bool {
+	return sec.HasKey(ManagedProfileContextKey) && sec.Key(ManagedProfileContextKey).String() == context
+}
+
+func setOrDeleteKeyInSection(sec *ini.Section, key string, value string) error {
+	if value == "" {
+		sec.DeleteKey(key)
+		return nil
+	}
+	return createNewKeyInSection(sec, key, value)
+}
+
+// configSectionName returns the section of a profile in the AWS config file, which, except for the default profile, is prefixed with "profile ".
+func configSectionName(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+	return "profile " + profile
+}
+
+func profileNameFromConfigSection(sectionName string) string {
+	return strings.TrimPrefix(sectionName, "profile ")
+}
+
+// WriteCredentialProcessProfile configures the profile in the AWS config file to retrieve its credentials with the
+// credential process. The other settings of the profile are kept.
+func WriteCredentialProcessProfile(profile string, credentialProcess string) error {
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return err
+	}
+	cfg, err := ini.Load(configFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read AWS config file: %w", err)
+	}
+	if err := setOrDeleteKeyInSection(cfg.Section(configSectionName(profile)), "credential_process", credentialProcess); err != nil {
+		return err
+	}
+	if err := cfg.SaveTo(configFilePath); err != nil {
+		return fmt.Errorf("AWS config file could not be saved: %w", err)
+	}
+	logrus.Debugf("Credential process saved to '%s' under profile section: [%s]", configFilePath, configSectionName(profile))
+	return nil
+}
+
+// RemoveCredentialProcessProfile removes the credential process written by WriteCredentialProcessProfile for one of the
+// roles from the profile in the AWS config file. A credential process of another role or of a profile managed by
+// sync-profiles is kept, the profile itself is removed when no keys are left.
+func RemoveCredentialProcessProfile(profile string, roleArns []string) error {
+	configFilePath, err := getConfigFilePath()
+	if err != nil {
+		return err
+	}
+	cfg, err := ini.Load(configFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read AWS config file: %w", err)
+	}
+	sec, err := cfg.GetSection(configSectionName(profile))
+	if err != nil || sec.HasKey(ManagedProfileContextKey) || !slices.Contains(roleArns, credentialProcessRoleArn(sec.Key("credential_process").String())) {
+		return nil
+	}
+	sec.DeleteKey("credential_process")
+	if len(sec.Keys()) == 0 {
+		cfg.DeleteSection(sec.Name())
+	}
+	if err := cfg.SaveTo(configFilePath); err != nil {
+		return fmt.Errorf("AWS config file could not be saved: %w", err)
+	}
+	logrus.Debugf("Removed credential process of profile section [%s] from '%s'", sec.Name(), configFilePath)
+	return nil
+}
+
+// credentialProcessRoleArn returns the role of an aws-keyhub credential process, or an empty string when it is not one.
+func credentialProcessRoleArn(credentialProcess string) string {
+	fields := strings.Fields(credentialProcess)
+	for i := 1; i+1 < len(fields); i++ {
+		if fields[i] == "--role-arn" && slices.Contains(fields[:i], "credential-process") {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
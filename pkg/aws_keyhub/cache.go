@@ -0,0 +1,290 @@
+package aws_keyhub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultCacheMarginSeconds = 300
+
+const (
+	credentialCacheLockTimeout = 10 * time.Second
+	credentialCacheLockStale   = 30 * time.Second
+	credentialCacheLockRetry   = 20 * time.Millisecond
+)
+
+// CredentialCacheFile holds the last STS session retrieved per role ARN.
+type CredentialCacheFile map[string]CachedCredentials
+
+type CachedCredentials struct {
+	RoleArn      string            `json:"roleArn"`
+	PrincipalArn string            `json:"principalArn"`
+	Description  string            `json:"description,omitempty"`
+	Profile      string            `json:"profile,omitempty"`
+	Credentials  types.Credentials `json:"credentials"`
+}
+
+func (cachedCredentials CachedCredentials) RolesAndPrincipals() RolesAndPrincipals {
+	return RolesAndPrincipals{
+		Role:        cachedCredentials.RoleArn,
+		Principal:   cachedCredentials.PrincipalArn,
+		Description: cachedCredentials.Description,
+	}
+}
+
+func (client *Client) GetCachedCredentialsForRole(roleArn string) (*CachedCredentials, error) {
+	cache, err := client.readCredentialCache()
+	if err != nil {
+		return nil, err
+	}
+	if cachedCredentials, exists := cache[roleArn]; exists && client.IsCachedCredentialValid(cachedCredentials) {
+		logrus.Debugln("Found valid cached credentials for role", roleArn)
+		return &cachedCredentials, nil
+	}
+	return nil, nil
+}
+
+func (client *Client) GetCachedCredentialsForProfile(profile string) (*CachedCredentials, error) {
+	cache, err := client.readCredentialCache()
+	if err != nil {
+		return nil, err
+	}
+	for _, cachedCredentials := range cache {
+		if cachedCredentials.Profile == profile && client.IsCachedCredentialValid(cachedCredentials) {
+			logrus.Debugln("Found valid cached credentials for profile", profile)
+			return &cachedCredentials, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetTrackedProfiles returns the cached credentials that were written to a profile, including expired ones, sorted by expiration.
+func (client *Client) GetTrackedProfiles() ([]CachedCredentials, error) {
+	cache, err := client.readCredentialCache()
+	if err != nil {
+		return nil, err
+	}
+	var tracked []CachedCredentials
+	for _, cachedCredentials := range cache {
+		if cachedCredentials.Profile != "" && cachedCredentials.Credentials.Expiration != nil {
+			tracked = append(tracked, cachedCredentials)
+		}
+	}
+	sort.Slice(tracked, func(i, j int) bool {
+		return tracked[i].Credentials.Expiration.Before(*tracked[j].Credentials.Expiration)
+	})
+	return tracked, nil
+}
+
+// StoreCachedCredentials caches the credentials for the role. An empty profile keeps the profile that was cached before,
+// another role cached for the profile is no longer tracked for it.
+func (client *Client) StoreCachedCredentials(roleAndPrincipal RolesAndPrincipals, profile string, credentials *types.Credentials) error {
+	unlock, err := client.lockCredentialCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	cache, err := client.readCredentialCache()
+	if err != nil {
+		return err
+	}
+	if existing, exists := cache[roleAndPrincipal.Role]; exists && profile == "" {
+		profile = existing.Profile
+	}
+	// The profile no longer holds the session of the role it was written with before.
+	for roleArn, cachedCredentials := range cache {
+		if profile != "" && cachedCredentials.Profile == profile && roleArn != roleAndPrincipal.Role {
+			cachedCredentials.Profile = ""
+			cache[roleArn] = cachedCredentials
+		}
+	}
+	cache[roleAndPrincipal.Role] = CachedCredentials{
+		RoleArn:      roleAndPrincipal.Role,
+		PrincipalArn: roleAndPrincipal.Principal,
+		Description:  roleAndPrincipal.Description,
+		Profile:      profile,
+		Credentials:  *credentials,
+	}
+	return client.writeCredentialCache(cache)
+}
+
+// IsCachedCredentialValid reports whether the credentials are valid for longer than the cache margin.
+func (client *Client) IsCachedCredentialValid(cachedCredentials CachedCredentials) bool {
+	expiration := cachedCredentials.Credentials.Expiration
+	if expiration == nil || cachedCredentials.Credentials.AccessKeyId == nil {
+		return false
+	}
+	return expiration.Add(-client.CacheMargin()).After(time.Now())
+}
+
+// CacheMargin is the time before expiration from which credentials are no longer reused.
+func (client *Client) CacheMargin() time.Duration {
+	margin := client.Config.Aws.CacheMarginSeconds
+	if margin <= 0 {
+		margin = DefaultCacheMarginSeconds
+	}
+	return time.Duration(margin) * time.Second
+}
+
+func getAwsKeyHubCredentialCachePathForContext(context string) (string, error) {
+	contextDirectory, err := getAwsKeyHubContextDirectoryForContext(context)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(contextDirectory, "credential-cache.json"), nil
+}
+
+func getAwsKeyHubEncryptedCredentialCachePathForContext(context string) (string, error) {
+	contextDirectory, err := getAwsKeyHubContextDirectoryForContext(context)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(contextDirectory, "credential-cache.json.enc"), nil
+}
+
+// credentialCachePath returns the path of the credential cache, which is encrypted when EncryptCredentials is set.
+func (client *Client) credentialCachePath() (string, error) {
+	if client.Config.Aws.EncryptCredentials {
+		return getAwsKeyHubEncryptedCredentialCachePathForContext(client.contextName)
+	}
+	return getAwsKeyHubCredentialCachePathForContext(client.contextName)
+}
+
+// credentialEncrypter returns the secret store that encrypts the credential cache.
+func (client *Client) credentialEncrypter() (credentialEncrypter, error) {
+	secretStore, err := client.getSecretStore()
+	if err != nil {
+		return nil, err
+	}
+	encrypter, ok := secretStore.(credentialEncrypter)
+	if !ok {
+		return nil, fmt.Errorf("encrypting credentials requires the %s or %s secret store", SecretStoreKeyring, SecretStoreEncryptedFile)
+	}
+	return encrypter, nil
+}
+
+func (client *Client) readCredentialCache() (CredentialCacheFile, error) {
+	cache := CredentialCacheFile{}
+	cachePath, err := client.credentialCachePath()
+	if err != nil {
+		return nil, err
+	}
+	dat, err := os.ReadFile(cachePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read aws-keyhub credential cache: %w", err)
+	}
+	if client.Config.Aws.EncryptCredentials {
+		encrypter, err := client.credentialEncrypter()
+		if err != nil {
+			return nil, err
+		}
+		// A wrong key or passphrase is an error, the cache would otherwise be overwritten on the next store.
+		if dat, err = encrypter.decrypt(client.contextName, dat); errors.Is(err, errCorruptCiphertext) {
+			logrus.Warningln("Ignoring corrupt encrypted aws-keyhub credential cache.", err)
+			return cache, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decrypt aws-keyhub credential cache: %w", err)
+		}
+	}
+	if err := json.Unmarshal(dat, &cache); err != nil {
+		logrus.Warningln("Ignoring unreadable aws-keyhub credential cache.", err)
+		return CredentialCacheFile{}, nil
+	}
+	return cache, nil
+}
+
+func (client *Client) writeCredentialCache(cache CredentialCacheFile) error {
+	res, err := json.Marshal(&cache)
+	if err != nil {
+		return fmt.Errorf("failed to marshal aws-keyhub credential cache: %w", err)
+	}
+	cachePath, err := client.credentialCachePath()
+	if err != nil {
+		return err
+	}
+	if client.Config.Aws.EncryptCredentials {
+		encrypter, err := client.credentialEncrypter()
+		if err != nil {
+			return err
+		}
+		if res, err = encrypter.encrypt(client.contextName, res); err != nil {
+			return fmt.Errorf("failed to encrypt aws-keyhub credential cache: %w", err)
+		}
+		// Do not leave the sessions of before the credentials were encrypted on disk.
+		plainCachePath, err := getAwsKeyHubCredentialCachePathForContext(client.contextName)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(plainCachePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove unencrypted aws-keyhub credential cache: %w", err)
+		}
+	}
+	// Readers never see a partially written cache.
+	if err := writeFileAtomically(cachePath, res); err != nil {
+		return fmt.Errorf("failed to write aws-keyhub credential cache: %w", err)
+	}
+	logrus.Debugln("Wrote aws-keyhub credential cache at", cachePath)
+	return nil
+}
+
+// lockCredentialCache keeps other aws-keyhub processes, like concurrent credential-process calls, from changing the
+// credential cache of the context until unlock is called. It waits while another process holds the lock. A lock file that is older than credentialCacheLockStale was left behind by a process that crashed.
+func (client *Client) lockCredentialCache() (unlock func(), err error) {
+	contextDirectory, err := getAwsKeyHubContextDirectoryForContext(client.contextName)
+	if err != nil {
+		return nil, err
+	}
+	lockPath := filepath.Join(contextDirectory, "credential-cache.lock")
+	deadline := time.Now().Add(credentialCacheLockTimeout)
+	for {
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			lockFile.Close()
+			return func() {
+				if err := os.Remove(lockPath); err != nil {
+					logrus.Warnln("Failed to remove the lock of the aws-keyhub credential cache.", err)
+				}
+			}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to lock aws-keyhub credential cache: %w", err)
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > credentialCacheLockStale {
+			logrus.Debugln("Removing stale lock of the aws-keyhub credential cache at", lockPath)
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the aws-keyhub credential cache is locked by another process, remove %s when no aws-keyhub is running", lockPath)
+		}
+		time.Sleep(credentialCacheLockRetry)
+	}
+}
+
+// writeFileAtomically writes the file next to the path and renames it over the path.
+func writeFileAtomically(path string, data []byte) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
@@ -0,0 +1,54 @@
+package aws_keyhub
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+)
+
+func TestStoreCachedCredentialsConcurrently(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	expiration := time.Now().Add(time.Hour)
+	credentials := &types.Credentials{AccessKeyId: aws.String("ASIACONCURRENT"), SecretAccessKey: aws.String("secret"), SessionToken: aws.String("token"), Expiration: &expiration}
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Go(func() {
+			roleArn := fmt.Sprintf("arn:aws:iam::123456789012:role/Role%d", i)
+			if err := client.StoreCachedCredentials(RolesAndPrincipals{Role: roleArn}, "", credentials); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	cache, err := client.readCredentialCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache) != 10 {
+		t.Errorf("credential cache has %d roles, want all 10 concurrently stored roles", len(cache))
+	}
+}
+
+func TestReadCredentialCacheError(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	cachePath, err := client.credentialCachePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reading a directory fails with another error than that the cache does not exist.
+	if err := os.Mkdir(cachePath, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.readCredentialCache(); err == nil {
+		t.Error("unreadable credential cache read as empty")
+	}
+}
@@ -0,0 +1,229 @@
+package aws_keyhub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/cli/browser"
+)
+
+// Client retrieves AWS credentials for the roles in the SAML assertion KeyHub issues to aws-keyhub. The refresh token
+// and credential cache of the context the client was created for are used, switching the current context does not
+// affect an existing client.
+type Client struct {
+	Config      KeyhubConfigFile
+	contextName string
+	httpClient  HTTPClient
+
+	stsMu     sync.Mutex
+	stsClient StsClient
+
+	secretStoreMu sync.Mutex
+	secretStore   SecretStore
+
+	openBrowser      func(url string) error // Nil when the device authorization has to be completed on another device.
+	deviceCodeOutput io.Writer
+	noPrompts        bool // An error is returned instead of prompting the user, for example for a role.
+	noAuthorization  bool // ErrAuthorizationRequired is returned instead of asking the user to authorize aws-keyhub.
+}
+
+// HTTPClient sends the requests to KeyHub, *http.Client implements it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// StsClient calls AWS STS, *sts.Client implements it.
+type StsClient interface {
+	AssumeRoleWithSAML(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error)
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+type ClientOption func(client *Client)
+
+// WithHTTPClient uses the HTTP client for the requests to KeyHub, for example to use a proxy, mTLS or tracing. The
+// allowInsecureTLS setting is not applied to it.
+func WithHTTPClient(httpClient HTTPClient) ClientOption {
+	return func(client *Client) {
+		client.httpClient = httpClient
+	}
+}
+
+// WithStsClient uses the STS client instead of one configured from the AWS CLI configuration and environment.
+func WithStsClient(stsClient StsClient) ClientOption {
+	return func(client *Client) {
+		client.stsClient = stsClient
+	}
+}
+
+// WithSecretStore stores the refresh token in the secret store instead of the one configured for the context.
+func WithSecretStore(secretStore SecretStore) ClientOption {
+	return func(client *Client) {
+		client.secretStore = secretStore
+	}
+}
+
+// SamlAssertion is the SAML assertion issued by KeyHub, together with the roles it grants access to.
+type SamlAssertion struct {
+	Assertion          string // Base64 encoded, as expected by AWS STS.
+	RolesAndPrincipals map[string]RolesAndPrincipals
+}
+
+// WithBrowser opens the KeyHub authorization page with the function instead of the default browser.
+func WithBrowser(openBrowser func(url string) error) ClientOption {
+	return func(client *Client) {
+		client.openBrowser = openBrowser
+	}
+}
+
+// WithoutBrowser does not open the KeyHub authorization page, the verification URI, user code and a QR code are
+// written to the device code output instead. This is the default when IsHeadless reports true.
+func WithoutBrowser() ClientOption {
+	return func(client *Client) {
+		client.openBrowser = nil
+	}
+}
+
+// WithDeviceCodeOutput writes the instructions to authorize aws-keyhub on another device to w instead of stderr.
+func WithDeviceCodeOutput(w io.Writer) ClientOption {
+	return func(client *Client) {
+		client.deviceCodeOutput = w
+	}
+}
+
+// WithContext uses the configuration, refresh token and credential cache of the context instead of the current context.
+func WithContext(name string) ClientOption {
+	return func(client *Client) {
+		client.contextName = name
+	}
+}
+
+// WithoutPrompts never prompts the user, for example for a role. An error is returned instead when the input is
+// missing or ambiguous.
+func WithoutPrompts() ClientOption {
+	return func(client *Client) {
+		client.noPrompts = true
+	}
+}
+
+// WithoutAuthorization never asks the user to authorize aws-keyhub in KeyHub, only the stored refresh token is used.
+// ErrAuthorizationRequired is returned when there is no valid refresh token.
+func WithoutAuthorization() ClientOption {
+	return func(client *Client) {
+		client.noAuthorization = true
+	}
+}
+
+func NewClient(config KeyhubConfigFile, options ...ClientOption) *Client {
+	client := newClient(options)
+	client.Config = config
+	if client.httpClient == nil {
+		client.httpClient = newHTTPClient(config)
+	}
+	return client
+}
+
+// LoadClient creates a client with the configuration of the current context, or of the context given with
+// WithContext.
+func LoadClient(options ...ClientOption) (*Client, error) {
+	client := newClient(options)
+	if !validContextName.MatchString(client.contextName) {
+		return nil, fmt.Errorf("invalid context name '%s', only letters, digits, '-' and '_' are allowed", client.contextName)
+	}
+	config, err := loadAwsKeyHubConfigForContext(client.contextName)
+	if err != nil {
+		return nil, err
+	}
+	client.Config = config
+	if client.httpClient == nil {
+		client.httpClient = newHTTPClient(config)
+	}
+	return client, nil
+}
+
+// newClient applies the options, the context is fixed to the current context when none was given.
+func newClient(options []ClientOption) *Client {
+	client := &Client{deviceCodeOutput: os.Stderr}
+	if !IsHeadless() {
+		client.openBrowser = browser.OpenURL
+	}
+	for _, option := range options {
+		option(client)
+	}
+	if client.contextName == "" {
+		client.contextName = GetContext()
+	}
+	return client
+}
+
+// Context returns the name of the context of the client.
+func (client *Client) Context() string {
+	return client.contextName
+}
+
+// getStsClient returns the injected STS client, or creates one from the AWS configuration on first use.
+func (client *Client) getStsClient(ctx context.Context) (StsClient, error) {
+	client.stsMu.Lock()
+	defer client.stsMu.Unlock()
+	if client.stsClient == nil {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure AWS SDK for STS call, please check your AWS CLI configuration: %w", err)
+		}
+		client.stsClient = sts.NewFromConfig(cfg)
+	}
+	return client.stsClient, nil
+}
+
+// getSecretStore returns the injected secret store, or creates the one configured for the context on first use.
+func (client *Client) getSecretStore() (SecretStore, error) {
+	client.secretStoreMu.Lock()
+	defer client.secretStoreMu.Unlock()
+	if client.secretStore == nil {
+		secretStore, err := NewSecretStore(client.Config.Keyhub.SecretStore)
+		if err != nil {
+			return nil, err
+		}
+		// The passphrase of the encrypted refresh token has to come from the environment when the client cannot prompt.
+		if encryptedFileStore, isEncryptedFile := secretStore.(*encryptedFileSecretStore); isEncryptedFile && client.noPrompts {
+			encryptedFileStore.passphrase = environmentPassphrase
+		}
+		client.secretStore = secretStore
+	}
+	return client.secretStore, nil
+}
+
+// RetrieveSamlAssertion logs in to KeyHub, using the refresh token when possible, and exchanges the access token for
+// a SAML assertion.
+func (client *Client) RetrieveSamlAssertion(ctx context.Context) (*SamlAssertion, error) {
+	loginResponse, err := client.DoLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.ExchangeForSamlAssertion(ctx, loginResponse)
+}
+
+// ExchangeForSamlAssertion exchanges the KeyHub access token for a SAML assertion.
+func (client *Client) ExchangeForSamlAssertion(ctx context.Context, loginResponse TokenExchangeResponse) (*SamlAssertion, error) {
+	exchangeTokenResponse, err := client.ExchangeToken(ctx, loginResponse)
+	if err != nil {
+		return nil, err
+	}
+	samlResponseDecoded, err := DecodeSAMLResponse(exchangeTokenResponse.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	rolesAndPrincipals, err := RolesAndPrincipalsFromSamlResponse(samlResponseDecoded)
+	if err != nil {
+		return nil, err
+	}
+	return &SamlAssertion{
+		Assertion:          exchangeTokenResponse.AccessToken,
+		RolesAndPrincipals: rolesAndPrincipals,
+	}, nil
+}
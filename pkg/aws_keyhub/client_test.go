@@ -0,0 +1,130 @@
+package aws_keyhub
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"testing/synctest"
+
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+)
+
+var testRoles = []keyhubtest.Role{
+	{RoleArn: "arn:aws:iam::123456789012:role/Admin", PrincipalArn: "arn:aws:iam::123456789012:saml-provider/keyhub", Description: "production - admin"},
+	{RoleArn: "arn:aws:iam::210987654321:role/ReadOnly", PrincipalArn: "arn:aws:iam::210987654321:saml-provider/keyhub", Description: "acceptance - read only"},
+}
+
+// newTestClient creates a client for the fake KeyHub and STS in an empty home directory. The browser is never opened.
+func newTestClient(t *testing.T, keyhub *keyhubtest.KeyHub, fakeSts *keyhubtest.Sts) *Client {
+	t.Helper()
+	keyhubtest.SetupHome(t)
+	config := KeyhubConfigFile{
+		Keyhub: KeyhubConfig{Url: keyhub.URL, ClientId: keyhubtest.ClientId, AwsSamlClientId: keyhubtest.AwsSamlClientId},
+		Aws:    KeyhubAwsConfig{AssumeDuration: 3600},
+	}
+	if err := AssureAwsKeyHubConfigDirectoryExists(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	options := []ClientOption{
+		WithHTTPClient(keyhub.Client()),
+		WithBrowser(func(url string) error { return nil }),
+	}
+	if fakeSts != nil {
+		options = append(options, WithStsClient(fakeSts.Client()))
+	}
+	return NewClient(config, options...)
+}
+
+func TestRetrieveSamlAssertionAndAssumeRole(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		fakeSts := keyhubtest.NewSts()
+		client := newTestClient(t, keyhub, fakeSts)
+		ctx := context.Background()
+
+		samlAssertion, err := client.RetrieveSamlAssertion(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roles := SortedRolesAndPrincipals(samlAssertion.RolesAndPrincipals)
+		if len(roles) != 2 || roles[0].Role != testRoles[0].RoleArn || roles[0].Description != testRoles[0].Description {
+			t.Fatalf("unexpected roles %v", roles)
+		}
+
+		output, err := client.StsAssumeRoleWithSAML(ctx, roles[0].Principal, roles[0].Role, samlAssertion.Assertion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output.Credentials == nil || output.Credentials.AccessKeyId == nil {
+			t.Fatalf("no credentials in %v", output)
+		}
+		if assumed := fakeSts.AssumedRoles(); !reflect.DeepEqual(assumed, []string{testRoles[0].RoleArn}) {
+			t.Errorf("assumed roles %v", assumed)
+		}
+	})
+}
+
+func TestStsAssumeRolesWithSAMLDenied(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles[0])
+		fakeSts := keyhubtest.NewSts()
+		client := newTestClient(t, keyhub, fakeSts)
+		ctx := context.Background()
+
+		samlAssertion, err := client.RetrieveSamlAssertion(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		granted := RolesAndPrincipals{Role: testRoles[0].RoleArn, Principal: testRoles[0].PrincipalArn}
+		notGranted := RolesAndPrincipals{Role: testRoles[1].RoleArn, Principal: testRoles[1].PrincipalArn}
+		outputs, err := client.StsAssumeRolesWithSAML(ctx, []RolesAndPrincipals{notGranted, granted}, samlAssertion.Assertion)
+		var stsError *StsError
+		if !errors.As(err, &stsError) || stsError.RoleArn != notGranted.Role {
+			t.Errorf("got %v, want an StsError for %s", err, notGranted.Role)
+		}
+		if len(outputs) != 2 || outputs[0] != nil || outputs[1] == nil || outputs[1].Credentials == nil {
+			t.Errorf("outputs %v, want only the output of the granted role", outputs)
+		}
+	})
+}
+
+func TestClientKeepsContext(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	otherDirectory, err := getAwsKeyHubContextDirectoryForContext("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(otherDirectory, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeConfigForContext("other", client.Config); err != nil {
+		t.Fatal(err)
+	}
+	if err := (fileSecretStore{}).Store(DefaultContext, []byte(`{"refresh_token":"default-refresh-token"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Switching the current context, as `context use` does while serve is running, does not affect the client.
+	if err := UseContext("other"); err != nil {
+		t.Fatal(err)
+	}
+	if refreshTokenFile, err := client.readRefreshToken(); err != nil || refreshTokenFile == nil || refreshTokenFile.RefreshToken != "default-refresh-token" {
+		t.Errorf("read %v, %v after switching context, want the refresh token of the default context", refreshTokenFile, err)
+	}
+
+	otherClient, err := LoadClient(WithContext("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherClient.Context() != "other" || client.Context() != DefaultContext {
+		t.Errorf("contexts %s and %s, want other and %s", otherClient.Context(), client.Context(), DefaultContext)
+	}
+	if refreshTokenFile, err := otherClient.readRefreshToken(); err != nil || refreshTokenFile != nil {
+		t.Errorf("read %v, %v for the other context, want no refresh token", refreshTokenFile, err)
+	}
+}
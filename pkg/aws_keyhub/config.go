@@ -2,19 +2,20 @@ package aws_keyhub
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"path/filepath"
-	"sync"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/sirupsen/logrus"
 )
 
-var awsKeyHubConfigFile KeyhubConfigFile
-var doOnceReadAwsKeyHubConfig sync.Once
-
-func ConfigureAwsKeyhub() {
+// ConfigureAwsKeyhub asks the user for the settings of the current context with a wizard.
+func ConfigureAwsKeyhub() error {
 	logrus.Println("aws-keyhub configuration wizard, please provide the following the information:")
 	var questions = []*survey.Question{
 		{
@@ -37,32 +38,117 @@ func ConfigureAwsKeyhub() {
 			Prompt:   &survey.Input{Message: "AWS assume role duration (in seconds, maximum value is 43200) ", Default: "43200"},
 			Validate: survey.Required,
 		},
+		{
+			Name: "grantType",
+			Prompt: &survey.Select{
+				Message: "KeyHub login method",
+				Options: GrantTypes,
+				Default: GrantTypeDeviceCode,
+				Description: func(value string, index int) string {
+					if value == GrantTypeAuthorizationCode {
+						return "authorization code with a redirect to aws-keyhub, requires the redirect URI http://127.0.0.1/callback"
+					}
+					return "device authorization, enter or confirm a code in KeyHub"
+				},
+			},
+		},
+		{
+			Name: "secretStore",
+			Prompt: &survey.Select{
+				Message: "Where to store the KeyHub refresh token",
+				Options: SecretStores,
+				Default: SecretStoreFile,
+				Description: func(value string, index int) string {
+					switch value {
+					case SecretStoreKeyring:
+						return "Secret Service (Linux), Keychain (macOS) or Credential Manager (Windows)"
+					case SecretStoreEncryptedFile:
+						return "file encrypted with a passphrase, set " + PassphraseEnvironmentVariable + " to skip the prompt"
+					}
+					return "plain text file in ~/.aws-keyhub"
+				},
+			},
+		},
+		{
+			Name:   "encryptCredentials",
+			Prompt: &survey.Confirm{Message: "Keep AWS sessions encrypted instead of writing them to ~/.aws/credentials (requires the keyring or encrypted-file secret store)?"},
+		},
+		{
+			Name:   "profileTemplate",
+			Prompt: &survey.Input{Message: "AWS profile name template (e.g. keyhub-{{.AccountId}}-{{.RoleName}}), leave empty to always use the `keyhub` profile"},
+		},
 	}
-	answers := struct {
-		KeyHubUrl             string
-		KeyHubClientId        string
-		KeyHubAwsSamlClientId string
-		AssumeDuration        int32
-	}{}
-
-	err := survey.Ask(questions, &answers)
+	var settings ConfigureSettings
+	err := survey.Ask(questions, &settings)
 	if err != nil {
-		logrus.Fatal("Failed to prompt user for configuration settings.", err)
+		return fmt.Errorf("failed to prompt user for configuration settings: %w", err)
 	}
+	return ConfigureAwsKeyhubWithSettings(settings)
+}
 
-	config := KeyhubConfigFile{
-		Aws: KeyhubAwsConfig{
-			AssumeDuration: answers.AssumeDuration,
-		},
-		Keyhub: KeyhubConfig{
-			Url:             answers.KeyHubUrl,
-			ClientId:        answers.KeyHubClientId,
-			AwsSamlClientId: answers.KeyHubAwsSamlClientId,
-		},
+// ConfigureSettings are the settings the configuration wizard asks for.
+type ConfigureSettings struct {
+	KeyHubUrl             string
+	KeyHubClientId        string
+	KeyHubAwsSamlClientId string
+	AssumeDuration        int32
+	GrantType             string
+	SecretStore           string
+	EncryptCredentials    bool
+	ProfileTemplate       string
+}
+
+// ConfigureAwsKeyhubWithSettings configures aws-keyhub without prompting, for example in a provisioning script. The
+// settings replace those of the current context, the settings the wizard does not ask for are kept.
+func ConfigureAwsKeyhubWithSettings(settings ConfigureSettings) error {
+	var missingSettings []string
+	if settings.KeyHubUrl == "" {
+		missingSettings = append(missingSettings, "KeyHub url")
+	}
+	if settings.KeyHubClientId == "" {
+		missingSettings = append(missingSettings, "KeyHub client id")
+	}
+	if settings.KeyHubAwsSamlClientId == "" {
+		missingSettings = append(missingSettings, "AWS SAML client id")
+	}
+	if len(missingSettings) > 0 {
+		return &ConfigNotFoundError{Context: GetContext(), MissingSettings: missingSettings}
+	}
+	if settings.AssumeDuration <= 0 {
+		return fmt.Errorf("invalid AWS assume role duration %d, it must be a positive number of seconds", settings.AssumeDuration)
+	}
+	if settings.GrantType != "" && !slices.Contains(GrantTypes, settings.GrantType) {
+		return fmt.Errorf("unsupported KeyHub grant type %q, use one of %s", settings.GrantType, strings.Join(GrantTypes, ", "))
+	}
+	if settings.SecretStore != "" && !slices.Contains(SecretStores, settings.SecretStore) {
+		return fmt.Errorf("unsupported secret store %q, use one of %s", settings.SecretStore, strings.Join(SecretStores, ", "))
+	}
+	if settings.EncryptCredentials && (settings.SecretStore == "" || settings.SecretStore == SecretStoreFile) {
+		return fmt.Errorf("encrypting AWS sessions requires the %s or %s secret store", SecretStoreKeyring, SecretStoreEncryptedFile)
+	}
+	if settings.ProfileTemplate != "" {
+		if _, err := parseProfileTemplate(settings.ProfileTemplate); err != nil {
+			return err
+		}
 	}
 
+	// Settings we do not prompt for are kept when reconfiguring.
+	config, err := LoadAwsKeyHubConfig()
+	var configNotFoundError *ConfigNotFoundError
+	if err != nil && !errors.As(err, &configNotFoundError) {
+		return err
+	}
+	config.Aws.AssumeDuration = settings.AssumeDuration
+	config.Aws.ProfileTemplate = settings.ProfileTemplate
+	config.Keyhub.Url = settings.KeyHubUrl
+	config.Keyhub.ClientId = settings.KeyHubClientId
+	config.Keyhub.AwsSamlClientId = settings.KeyHubAwsSamlClientId
+	config.Keyhub.GrantType = settings.GrantType
+	config.Keyhub.SecretStore = settings.SecretStore
+	config.Aws.EncryptCredentials = settings.EncryptCredentials
+
 	logrus.Debugln(config)
-	writeConfig(config)
+	return writeConfig(config)
 }
 
 type KeyhubConfigFile struct {
@@ -74,73 +160,192 @@ type KeyhubConfig struct {
 	Url              string `json:"url"`
 	ClientId         string `json:"clientId"`
 	AwsSamlClientId  string `json:"awsSamlClientId"`
-	AllowInsecureTLS bool   `json:"allowInsecureTLS"` // We do not prompt for this flag, but it is configurable for development purposes.
+	AllowInsecureTLS bool   `json:"allowInsecureTLS"`      // We do not prompt for this flag, but it is configurable for development purposes.
+	GrantType        string `json:"grantType,omitempty"`   // How the user authorizes aws-keyhub, GrantTypeDeviceCode when empty.
+	SecretStore      string `json:"secretStore,omitempty"` // Where the refresh token is stored, SecretStoreFile when empty.
 }
 
+const (
+	// GrantTypeDeviceCode lets the user authorize aws-keyhub with the OAuth2 device authorization grant (RFC 8628).
+	GrantTypeDeviceCode = "device_code"
+	// GrantTypeAuthorizationCode lets the user authorize aws-keyhub with the OAuth2 authorization code grant with PKCE
+	// and a loopback redirect URI. The device authorization grant is used when no browser is available.
+	GrantTypeAuthorizationCode = "authorization_code"
+)
+
+var GrantTypes = []string{GrantTypeDeviceCode, GrantTypeAuthorizationCode}
+
 type KeyhubAwsConfig struct {
-	AssumeDuration int32 `json:"assumeDuration"`
+	AssumeDuration     int32             `json:"assumeDuration"`
+	CacheMarginSeconds int32             `json:"cacheMarginSeconds,omitempty"` // Cached credentials are reused until they expire within this margin, defaults to DefaultCacheMarginSeconds.
+	ProfileTemplate    string            `json:"profileTemplate,omitempty"`
+	Profiles           map[string]string `json:"profiles,omitempty"` // The profile that was last used per role ARN.
+	// EncryptCredentials keeps the STS sessions in the encrypted credential cache instead of the AWS credentials
+	// file, profiles retrieve them with credential_process. Requires the keyring or encrypted-file secret store.
+	EncryptCredentials bool `json:"encryptCredentials,omitempty"`
+	// FavoriteRoles are the role ARNs pinned at the top of the role picker.
+	FavoriteRoles []string `json:"favoriteRoles,omitempty"`
+	// RecentRoles are the last selected role ARNs, most recent first, shown below the favorites in the role picker.
+	RecentRoles []string `json:"recentRoles,omitempty"`
+	// AccountAliases are the names shown for the account IDs in the role picker.
+	AccountAliases map[string]string `json:"accountAliases,omitempty"`
 }
 
-func CheckIfAwsKeyHubConfigFileExists() {
-	if _, err := os.Stat(getAwsKeyHubConfigFilePath()); os.IsNotExist(err) {
-		logrus.Fatal("It looks like you have no aws-keyhub configuration file. Please run `aws-keyhub configure` first.")
+// MaxRecentRoles is the number of recently selected roles that is remembered.
+const MaxRecentRoles = 5
+
+func CheckIfAwsKeyHubConfigFileExists() error {
+	configFilePath, err := getAwsKeyHubConfigFilePath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(configFilePath); os.IsNotExist(err) {
+		return &ConfigNotFoundError{Context: GetContext()}
 	}
 	logrus.Debugln("aws-keyhub configuration file exists.")
+	return nil
 }
 
-func AssureAwsKeyHubConfigDirectoryExists() {
-	configDirectory := getAwsKeyHubConfigDirectory()
+func AssureAwsKeyHubConfigDirectoryExists() error {
+	configDirectory, err := getAwsKeyHubContextDirectory()
+	if err != nil {
+		return err
+	}
 
 	logContext := logrus.WithFields(logrus.Fields{
 		"directory": configDirectory,
 	})
 	if _, err := os.Stat(configDirectory); os.IsNotExist(err) {
-		err = os.Mkdir(configDirectory, 0700)
+		err = os.MkdirAll(configDirectory, 0700)
 		if err != nil {
-			logContext.Fatalln("Failed to create config directory", err)
+			return fmt.Errorf("failed to create config directory %s: %w", configDirectory, err)
 		}
 		logContext.Debugln("Config directory created")
-		return
+		return nil
 	}
 	logContext.Debugln("Config directory already exists")
+	return nil
 }
 
-func getAwsKeyHubConfigDirectory() string {
-	return filepath.Join(getUserHomeDir(), ".aws-keyhub")
+func getAwsKeyHubConfigDirectory() (string, error) {
+	userHomeDir, err := getUserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userHomeDir, ".aws-keyhub"), nil
 }
 
-func getAwsKeyHubConfigFilePath() string {
-	return filepath.Join(getAwsKeyHubConfigDirectory(), "config-v2.json")
+func getAwsKeyHubConfigFilePath() (string, error) {
+	return getAwsKeyHubConfigFilePathForContext(GetContext())
 }
 
-func GetAwsKeyHubRefreshTokenPath() string {
-	return filepath.Join(getAwsKeyHubConfigDirectory(), "refresh-token.json")
+func getAwsKeyHubConfigFilePathForContext(context string) (string, error) {
+	contextDirectory, err := getAwsKeyHubContextDirectoryForContext(context)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(contextDirectory, "config-v2.json"), nil
 }
 
-func getAwsKeyHubConfig() KeyhubConfigFile {
-	doOnceReadAwsKeyHubConfig.Do(func() {
-		dat, err := os.ReadFile(getAwsKeyHubConfigFilePath())
-		if err != nil {
-			logrus.Fatal("Failed to read aws-keyhub configuration file.", err)
-		}
-		err = json.Unmarshal(dat, &awsKeyHubConfigFile)
-		if err != nil {
-			logrus.Fatal("Failed to unmarshal aws-keyhub configuration file.", err)
-		}
-		logrus.Debugln("Read aws-keyhub configuration file", awsKeyHubConfigFile)
-	})
+func GetAwsKeyHubRefreshTokenPath() (string, error) {
+	return getAwsKeyHubRefreshTokenPathForContext(GetContext())
+}
+
+func getAwsKeyHubRefreshTokenPathForContext(context string) (string, error) {
+	contextDirectory, err := getAwsKeyHubContextDirectoryForContext(context)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(contextDirectory, "refresh-token.json"), nil
+}
+
+// LoadAwsKeyHubConfig reads the configuration file of the current context.
+func LoadAwsKeyHubConfig() (KeyhubConfigFile, error) {
+	return loadAwsKeyHubConfigForContext(GetContext())
+}
+
+func loadAwsKeyHubConfigForContext(context string) (KeyhubConfigFile, error) {
+	var config KeyhubConfigFile
+	configFilePath, err := getAwsKeyHubConfigFilePathForContext(context)
+	if err != nil {
+		return config, err
+	}
+	dat, err := os.ReadFile(configFilePath)
+	if os.IsNotExist(err) {
+		return config, &ConfigNotFoundError{Context: context}
+	} else if err != nil {
+		return config, fmt.Errorf("failed to read aws-keyhub configuration file: %w", err)
+	}
+	if err := json.Unmarshal(dat, &config); err != nil {
+		return config, fmt.Errorf("failed to unmarshal aws-keyhub configuration file %s: %w", configFilePath, err)
+	}
+	logrus.Debugln("Read aws-keyhub configuration file", config)
+	return config, nil
+}
 
-	return awsKeyHubConfigFile
+func writeConfig(config KeyhubConfigFile) error {
+	return writeConfigForContext(GetContext(), config)
 }
 
-func writeConfig(config KeyhubConfigFile) {
+func writeConfigForContext(context string, config KeyhubConfigFile) error {
 	res, err := json.MarshalIndent(&config, "", "\t")
 	if err != nil {
-		logrus.Fatal("Failed to marshal aws-keyhub configuration file.", err)
+		return fmt.Errorf("failed to marshal aws-keyhub configuration file: %w", err)
+	}
+	configFilePath, err := getAwsKeyHubConfigFilePathForContext(context)
+	if err != nil {
+		return err
 	}
-	err = os.WriteFile(getAwsKeyHubConfigFilePath(), res, 0600)
+	err = os.WriteFile(configFilePath, res, 0600)
 	if err != nil {
-		logrus.Fatal("Failed to write aws-keyhub configuration file.", err)
+		return fmt.Errorf("failed to write aws-keyhub configuration file: %w", err)
+	}
+	logrus.Debugln("Wrote aws-keyhub configuration file at", configFilePath)
+	return nil
+}
+
+// StoreProfileForRole remembers the profile of the role, so the next login with this role uses the same profile.
+func (client *Client) StoreProfileForRole(roleArn string, profile string) error {
+	if client.Config.Aws.Profiles[roleArn] == profile {
+		return nil
+	}
+	if client.Config.Aws.Profiles == nil {
+		client.Config.Aws.Profiles = make(map[string]string)
+	}
+	client.Config.Aws.Profiles[roleArn] = profile
+	return writeConfigForContext(client.contextName, client.Config)
+}
+
+// StoreRecentRoles moves the roles to the front of the recently selected roles, the first role is the most recent.
+func (client *Client) StoreRecentRoles(roleArns ...string) error {
+	var recentRoles []string
+	for _, roleArn := range slices.Concat(roleArns, client.Config.Aws.RecentRoles) {
+		if !slices.Contains(recentRoles, roleArn) && len(recentRoles) < MaxRecentRoles {
+			recentRoles = append(recentRoles, roleArn)
+		}
+	}
+	if slices.Equal(recentRoles, client.Config.Aws.RecentRoles) {
+		return nil
+	}
+	client.Config.Aws.RecentRoles = recentRoles
+	return writeConfigForContext(client.contextName, client.Config)
+}
+
+// AddFavoriteRole pins the role at the top of the role picker, it is not an error when the role already is a favorite.
+func (client *Client) AddFavoriteRole(roleArn string) error {
+	if slices.Contains(client.Config.Aws.FavoriteRoles, roleArn) {
+		return nil
+	}
+	client.Config.Aws.FavoriteRoles = append(client.Config.Aws.FavoriteRoles, roleArn)
+	return writeConfigForContext(client.contextName, client.Config)
+}
+
+// RemoveFavoriteRole unpins the role, it reports whether the role was a favorite.
+func (client *Client) RemoveFavoriteRole(roleArn string) (bool, error) {
+	index := slices.Index(client.Config.Aws.FavoriteRoles, roleArn)
+	if index < 0 {
+		return false, nil
 	}
-	logrus.Debugln("Wrote aws-keyhub configuration file at", getAwsKeyHubConfigFilePath())
+	client.Config.Aws.FavoriteRoles = slices.Delete(client.Config.Aws.FavoriteRoles, index, index+1)
+	return true, writeConfigForContext(client.contextName, client.Config)
 }
@@ -0,0 +1,194 @@
+package aws_keyhub
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultContext is the context that is used when no context is selected. Its files are stored directly in the
+// aws-keyhub config directory, so configurations created before contexts existed keep working.
+const DefaultContext = "default"
+
+var selectedContext string
+var validContextName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// SetContext selects the context for the remainder of this run, overriding the context chosen with `context use`.
+func SetContext(name string) error {
+	if !validContextName.MatchString(name) {
+		return fmt.Errorf("invalid context name '%s', only letters, digits, '-' and '_' are allowed", name)
+	}
+	selectedContext = name
+	logrus.Debugln("Using aws-keyhub context", name)
+	return nil
+}
+
+func GetContext() string {
+	if selectedContext != "" {
+		return selectedContext
+	}
+	currentContextPath, err := getAwsKeyHubCurrentContextPath()
+	if err != nil {
+		return DefaultContext
+	}
+	dat, err := os.ReadFile(currentContextPath)
+	if err != nil {
+		return DefaultContext
+	}
+	name := strings.TrimSpace(string(dat))
+	if !validContextName.MatchString(name) {
+		logrus.Warningf("Ignoring invalid current context '%s', using '%s'.", name, DefaultContext)
+		return DefaultContext
+	}
+	return name
+}
+
+func UseContext(name string) error {
+	exists, err := contextExists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return &ConfigNotFoundError{Context: name}
+	}
+	currentContextPath, err := getAwsKeyHubCurrentContextPath()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(currentContextPath, []byte(name+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write current aws-keyhub context: %w", err)
+	}
+	logrus.Debugln("Switched to aws-keyhub context", name)
+	return nil
+}
+
+func ListContexts() ([]string, error) {
+	var contexts []string
+	exists, err := contextExists(DefaultContext)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		contexts = append(contexts, DefaultContext)
+	}
+	contextsDirectory, err := getAwsKeyHubContextsDirectory()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(contextsDirectory)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read aws-keyhub contexts directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == DefaultContext {
+			continue
+		}
+		if exists, err := contextExists(entry.Name()); err != nil {
+			return nil, err
+		} else if exists {
+			contexts = append(contexts, entry.Name())
+		}
+	}
+	sort.Strings(contexts)
+	return contexts, nil
+}
+
+func DeleteContext(name string) error {
+	exists, err := contextExists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return &ConfigNotFoundError{Context: name}
+	}
+	// The refresh token may be stored outside the context directory, in the keyring.
+	if config, err := loadAwsKeyHubConfigForContext(name); err == nil {
+		if secretStore, err := NewSecretStore(config.Keyhub.SecretStore); err == nil {
+			if err := secretStore.Delete(name); err != nil {
+				logrus.Warnln("Failed to remove the refresh token of context", name, err)
+			}
+			if keyringStore, isKeyring := secretStore.(keyringSecretStore); isKeyring {
+				if err := keyringStore.Delete(credentialCacheKeyAccount(name)); err != nil {
+					logrus.Warnln("Failed to remove the credential cache key of context", name, err)
+				}
+			}
+		}
+	}
+	if name == DefaultContext {
+		for _, pathForContext := range []func(string) (string, error){getAwsKeyHubConfigFilePathForContext, getAwsKeyHubRefreshTokenPathForContext, getAwsKeyHubEncryptedRefreshTokenPathForContext, getAwsKeyHubCredentialCachePathForContext, getAwsKeyHubEncryptedCredentialCachePathForContext} {
+			path, err := pathForContext(name)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+		}
+	} else {
+		contextDirectory, err := getAwsKeyHubContextDirectoryForContext(name)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(contextDirectory); err != nil {
+			return fmt.Errorf("failed to remove context '%s': %w", name, err)
+		}
+	}
+
+	if GetContext() == name {
+		currentContextPath, err := getAwsKeyHubCurrentContextPath()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(currentContextPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to reset current aws-keyhub context: %w", err)
+		}
+	}
+	logrus.Debugln("Deleted aws-keyhub context", name)
+	return nil
+}
+
+func contextExists(name string) (bool, error) {
+	configFilePath, err := getAwsKeyHubConfigFilePathForContext(name)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(configFilePath)
+	return err == nil, nil
+}
+
+func getAwsKeyHubCurrentContextPath() (string, error) {
+	configDirectory, err := getAwsKeyHubConfigDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDirectory, "current-context"), nil
+}
+
+func getAwsKeyHubContextsDirectory() (string, error) {
+	configDirectory, err := getAwsKeyHubConfigDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDirectory, "contexts"), nil
+}
+
+func getAwsKeyHubContextDirectory() (string, error) {
+	return getAwsKeyHubContextDirectoryForContext(GetContext())
+}
+
+func getAwsKeyHubContextDirectoryForContext(name string) (string, error) {
+	if name == DefaultContext {
+		return getAwsKeyHubConfigDirectory()
+	}
+	contextsDirectory, err := getAwsKeyHubContextsDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(contextsDirectory, name), nil
+}
@@ -0,0 +1,151 @@
+package aws_keyhub
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+)
+
+// setupContexts configures a default and an acc context in an empty home directory, and returns the home directory.
+func setupContexts(t *testing.T) string {
+	t.Helper()
+	home := keyhubtest.SetupHome(t)
+	t.Cleanup(func() { selectedContext = "" })
+	for _, context := range []string{DefaultContext, "acc"} {
+		if err := SetContext(context); err != nil {
+			t.Fatal(err)
+		}
+		if err := AssureAwsKeyHubConfigDirectoryExists(); err != nil {
+			t.Fatal(err)
+		}
+		settings := ConfigureSettings{KeyHubUrl: "https://" + context + ".keyhub.test", KeyHubClientId: keyhubtest.ClientId, KeyHubAwsSamlClientId: keyhubtest.AwsSamlClientId, AssumeDuration: 3600}
+		if err := ConfigureAwsKeyhubWithSettings(settings); err != nil {
+			t.Fatal(err)
+		}
+	}
+	selectedContext = ""
+	return home
+}
+
+func TestContexts(t *testing.T) {
+	home := setupContexts(t)
+
+	if contexts, err := ListContexts(); err != nil || !slices.Equal(contexts, []string{"acc", DefaultContext}) {
+		t.Errorf("ListContexts() = %v, %v, want acc and default", contexts, err)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".aws-keyhub", "contexts", "acc", "config-v2.json")); err != nil {
+		t.Errorf("configuration of context acc not stored in its own directory: %v", err)
+	}
+	if context := GetContext(); context != DefaultContext {
+		t.Errorf("GetContext() = %q before switching, want %q", context, DefaultContext)
+	}
+
+	if err := UseContext("acc"); err != nil {
+		t.Fatal(err)
+	}
+	if context := GetContext(); context != "acc" {
+		t.Errorf("GetContext() = %q after switching, want acc", context)
+	}
+	if config, err := LoadAwsKeyHubConfig(); err != nil || config.Keyhub.Url != "https://acc.keyhub.test" {
+		t.Errorf("loaded %q, %v, want the configuration of context acc", config.Keyhub.Url, err)
+	}
+	var configNotFoundError *ConfigNotFoundError
+	if err := UseContext("prd"); !errors.As(err, &configNotFoundError) || configNotFoundError.Context != "prd" {
+		t.Errorf("switching to an unknown context returned %v", err)
+	}
+	if err := SetContext("../prd"); err == nil {
+		t.Error("selected a context with an invalid name")
+	}
+
+	if err := DeleteContext("acc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".aws-keyhub", "contexts", "acc")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("directory of deleted context acc still exists: %v", err)
+	}
+	if context := GetContext(); context != DefaultContext {
+		t.Errorf("GetContext() = %q after deleting the current context, want %q", context, DefaultContext)
+	}
+	if contexts, err := ListContexts(); err != nil || !slices.Equal(contexts, []string{DefaultContext}) {
+		t.Errorf("ListContexts() = %v, %v after delete, want default", contexts, err)
+	}
+}
+
+func TestContextOverride(t *testing.T) {
+	setupContexts(t)
+	if err := UseContext("acc"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The --context flag overrides the current-context file for the remainder of the run.
+	if err := SetContext(DefaultContext); err != nil {
+		t.Fatal(err)
+	}
+	if context := GetContext(); context != DefaultContext {
+		t.Errorf("GetContext() = %q, want the selected context %q", context, DefaultContext)
+	}
+	client, err := LoadClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Context() != DefaultContext || client.Config.Keyhub.Url != "https://default.keyhub.test" {
+		t.Errorf("client uses context %q with %q, want the selected context", client.Context(), client.Config.Keyhub.Url)
+	}
+
+	// A client keeps the context it was created for.
+	client, err = LoadClient(WithContext("acc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Context() != "acc" || client.Config.Keyhub.Url != "https://acc.keyhub.test" {
+		t.Errorf("client uses context %q with %q, want acc", client.Context(), client.Config.Keyhub.Url)
+	}
+}
+
+func TestLegacyStateIsDefaultContext(t *testing.T) {
+	home := keyhubtest.SetupHome(t)
+	t.Cleanup(func() { selectedContext = "" })
+	// Before contexts existed, the configuration and refresh token were stored directly in ~/.aws-keyhub.
+	configDirectory := filepath.Join(home, ".aws-keyhub")
+	if err := os.MkdirAll(configDirectory, 0700); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"keyhub":{"url":"https://legacy.keyhub.test","clientId":"` + keyhubtest.ClientId + `","awsSamlClientId":"` + keyhubtest.AwsSamlClientId + `"},"aws":{"assumeDuration":3600}}`
+	if err := os.WriteFile(filepath.Join(configDirectory, "config-v2.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDirectory, "refresh-token.json"), []byte(`{"refresh_token":"legacy-refresh-token"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := LoadClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Context() != DefaultContext || client.Config.Keyhub.Url != "https://legacy.keyhub.test" {
+		t.Errorf("client uses context %q with %q, want the legacy configuration as the default context", client.Context(), client.Config.Keyhub.Url)
+	}
+	if refreshTokenFile, err := client.readRefreshToken(); err != nil || refreshTokenFile == nil || refreshTokenFile.RefreshToken != "legacy-refresh-token" {
+		t.Errorf("read %v, %v, want the legacy refresh token", refreshTokenFile, err)
+	}
+	if contexts, err := ListContexts(); err != nil || !slices.Equal(contexts, []string{DefaultContext}) {
+		t.Errorf("ListContexts() = %v, %v, want default", contexts, err)
+	}
+
+	// Deleting the default context removes the legacy files, but not the directory holding the other contexts.
+	if err := DeleteContext(DefaultContext); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"config-v2.json", "refresh-token.json"} {
+		if _, err := os.Stat(filepath.Join(configDirectory, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s still exists after deleting the default context: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(configDirectory); err != nil {
+		t.Errorf("aws-keyhub config directory removed with the default context: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package aws_keyhub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/sirupsen/logrus"
+)
+
+// CredentialProcessOutput is the JSON document the AWS CLI and SDKs expect from a `credential_process`.
+// See https://docs.aws.amazon.com/cli/latest/userguide/cli-configure-sourcing-external.html
+type CredentialProcessOutput struct {
+	Version         int        `json:"Version"`
+	AccessKeyId     string     `json:"AccessKeyId"`
+	SecretAccessKey string     `json:"SecretAccessKey"`
+	SessionToken    string     `json:"SessionToken"`
+	Expiration      *time.Time `json:"Expiration,omitempty"`
+}
+
+func NewCredentialProcessOutput(credentials *types.Credentials) CredentialProcessOutput {
+	output := CredentialProcessOutput{
+		Version:         1,
+		AccessKeyId:     *credentials.AccessKeyId,
+		SecretAccessKey: *credentials.SecretAccessKey,
+		SessionToken:    *credentials.SessionToken,
+	}
+	if credentials.Expiration != nil {
+		expiration := credentials.Expiration.UTC()
+		output.Expiration = &expiration
+	}
+	return output
+}
+
+func WriteCredentialProcessOutput(writer io.Writer, credentials *types.Credentials) error {
+	output := NewCredentialProcessOutput(credentials)
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		return fmt.Errorf("failed to write credential process output: %w", err)
+	}
+	logrus.Debugln("Wrote credential process output, expiration:", output.Expiration)
+	return nil
+}
@@ -0,0 +1,286 @@
+package aws_keyhub
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+const CredentialServerPath = "/credentials"
+const imdsCredentialsPath = "/latest/meta-data/iam/security-credentials/"
+const imdsTokenPath = "/latest/api/token"
+
+// CredentialServer serves credentials to the AWS CLI and SDKs over HTTP, in the format of the ECS container credentials
+// endpoint (AWS_CONTAINER_CREDENTIALS_FULL_URI) and optionally the EC2 instance metadata service (IMDSv2).
+type CredentialServer struct {
+	// AuthorizationToken has to be sent in the Authorization header (AWS_CONTAINER_AUTHORIZATION_TOKEN).
+	AuthorizationToken string
+	// Retrieve is called for new credentials when there are none yet or they are about to expire.
+	Retrieve func(ctx context.Context) (RolesAndPrincipals, *types.Credentials, error)
+	// CacheMargin is the time before expiration at which new credentials are retrieved, DefaultCacheMarginSeconds when zero.
+	CacheMargin time.Duration
+	// EnableIMDS also serves the credentials on the IMDSv2 paths.
+	EnableIMDS bool
+	// Context is passed to Retrieve, context.Background() when nil. A request that is cancelled stops waiting for the
+	// retrieval, but does not cancel it for the other requests.
+	Context context.Context
+
+	retrieval        singleflight.Group
+	mu               sync.Mutex
+	roleAndPrincipal RolesAndPrincipals
+	credentials      *types.Credentials
+	lastUpdated      time.Time
+	imdsTokens       map[string]time.Time
+}
+
+type containerCredentialsResponse struct {
+	AccessKeyId     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+	RoleArn         string    `json:"RoleArn,omitempty"`
+}
+
+type imdsCredentialsResponse struct {
+	Code            string    `json:"Code"`
+	LastUpdated     time.Time `json:"LastUpdated"`
+	Type            string    `json:"Type"`
+	AccessKeyId     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+func NewAuthorizationToken() string {
+	token := make([]byte, 32)
+	rand.Read(token) // Never returns an error, it crashes the program instead.
+	return hex.EncodeToString(token)
+}
+
+func (server *CredentialServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+CredentialServerPath, server.handleContainerCredentials)
+	if server.EnableIMDS {
+		mux.HandleFunc("PUT "+imdsTokenPath, server.handleImdsToken)
+		mux.HandleFunc("GET "+imdsCredentialsPath, server.handleImdsRoleName)
+		mux.HandleFunc("GET "+imdsCredentialsPath+"{role}", server.handleImdsCredentials)
+	}
+	return rejectBrowserRequests(mux)
+}
+
+// rejectBrowserRequests protects against DNS rebinding, a web page that resolves its own host name to the loopback
+// address to request the credentials. Such requests carry that host name and, from a browser, an Origin header, the
+// AWS CLI and SDKs send neither.
+func rejectBrowserRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if r.Header.Get("Origin") != "" || !IsLoopbackAddress(strings.Trim(host, "[]")) {
+			logrus.Warnf("Denied request for host %q with origin %q from %s.", r.Host, r.Header.Get("Origin"), r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsLoopbackAddress reports whether the host name or IP address only accepts connections from this machine.
+func IsLoopbackAddress(address string) bool {
+	if address == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Credentials returns the current credentials, retrieving new ones when they expire within the cache margin. Concurrent
+// callers share a single retrieval, which is done without holding the lock so the other requests are not blocked by
+// a KeyHub authorization. The retrieval uses the Context of the server, ctx only limits how long the caller waits.
+func (server *CredentialServer) Credentials(ctx context.Context) (RolesAndPrincipals, *types.Credentials, error) {
+	if roleAndPrincipal, credentials, valid := server.currentCredentials(); valid {
+		return roleAndPrincipal, credentials, nil
+	}
+	retrieval := server.retrieval.DoChan("credentials", func() (any, error) {
+		// The credentials may have been retrieved while waiting for the previous retrieval.
+		if _, _, valid := server.currentCredentials(); valid {
+			return nil, nil
+		}
+		logrus.Infoln("Retrieving new credentials.")
+		retrieveCtx := server.Context
+		if retrieveCtx == nil {
+			retrieveCtx = context.Background()
+		}
+		roleAndPrincipal, credentials, err := server.Retrieve(retrieveCtx)
+		if err != nil {
+			return nil, err
+		}
+		server.mu.Lock()
+		server.roleAndPrincipal, server.credentials = roleAndPrincipal, credentials
+		server.lastUpdated = time.Now()
+		server.mu.Unlock()
+		logrus.Infof("Serving credentials for role %s, valid until %s.", roleAndPrincipal.Role, credentials.Expiration.Local().Format(time.RFC1123))
+		return nil, nil
+	})
+	select {
+	case <-ctx.Done():
+		return RolesAndPrincipals{}, nil, ctx.Err()
+	case result := <-retrieval:
+		if result.Err != nil {
+			return RolesAndPrincipals{}, nil, result.Err
+		}
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.roleAndPrincipal, server.credentials, nil
+}
+
+// currentCredentials returns the current credentials and whether they are valid for longer than the cache margin.
+func (server *CredentialServer) currentCredentials() (RolesAndPrincipals, *types.Credentials, bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	valid := server.credentials != nil && time.Until(*server.credentials.Expiration) >= server.cacheMargin()
+	return server.roleAndPrincipal, server.credentials, valid
+}
+
+func (server *CredentialServer) cacheMargin() time.Duration {
+	if server.CacheMargin > 0 {
+		return server.CacheMargin
+	}
+	return DefaultCacheMarginSeconds * time.Second
+}
+
+// RefreshBeforeExpiry retrieves new credentials shortly before the current ones expire, until the context is done.
+// When retrieving fails it is retried every minute.
+func (server *CredentialServer) RefreshBeforeExpiry(ctx context.Context) {
+	for {
+		wait := time.Minute
+		if _, credentials, err := server.Credentials(ctx); err != nil {
+			logrus.Errorln("Failed to retrieve new credentials.", err)
+		} else {
+			wait = max(time.Until(credentials.Expiration.Add(-server.cacheMargin())), time.Minute)
+		}
+		logrus.Debugf("Refreshing credentials in %s", wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (server *CredentialServer) handleContainerCredentials(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(server.AuthorizationToken)) != 1 {
+		logrus.Warnln("Denied credentials request with invalid authorization token from", r.RemoteAddr)
+		http.Error(w, "invalid authorization token", http.StatusUnauthorized)
+		return
+	}
+	roleAndPrincipal, credentials, err := server.Credentials(r.Context())
+	if err != nil {
+		server.handleRetrieveError(w, err)
+		return
+	}
+	writeJson(w, containerCredentialsResponse{
+		AccessKeyId:     *credentials.AccessKeyId,
+		SecretAccessKey: *credentials.SecretAccessKey,
+		Token:           *credentials.SessionToken,
+		Expiration:      credentials.Expiration.UTC(),
+		RoleArn:         roleAndPrincipal.Role,
+	})
+}
+
+func (server *CredentialServer) handleImdsToken(w http.ResponseWriter, r *http.Request) {
+	ttlSeconds, err := strconv.Atoi(r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"))
+	ttl := time.Duration(ttlSeconds) * time.Second
+	if err != nil || ttl <= 0 || ttl > 6*time.Hour {
+		http.Error(w, "invalid X-aws-ec2-metadata-token-ttl-seconds header", http.StatusBadRequest)
+		return
+	}
+	token := NewAuthorizationToken()
+	server.mu.Lock()
+	if server.imdsTokens == nil {
+		server.imdsTokens = make(map[string]time.Time)
+	}
+	for existingToken, expiration := range server.imdsTokens {
+		if expiration.Before(time.Now()) {
+			delete(server.imdsTokens, existingToken)
+		}
+	}
+	server.imdsTokens[token] = time.Now().Add(ttl)
+	server.mu.Unlock()
+	w.Header().Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(ttlSeconds))
+	w.Write([]byte(token))
+}
+
+func (server *CredentialServer) handleImdsRoleName(w http.ResponseWriter, r *http.Request) {
+	if !server.isValidImdsToken(r) {
+		http.Error(w, "invalid metadata token", http.StatusUnauthorized)
+		return
+	}
+	roleAndPrincipal, _, err := server.Credentials(r.Context())
+	if err != nil {
+		server.handleRetrieveError(w, err)
+		return
+	}
+	w.Write([]byte(roleNameFromArn(roleAndPrincipal.Role)))
+}
+
+func (server *CredentialServer) handleImdsCredentials(w http.ResponseWriter, r *http.Request) {
+	if !server.isValidImdsToken(r) {
+		http.Error(w, "invalid metadata token", http.StatusUnauthorized)
+		return
+	}
+	roleAndPrincipal, credentials, err := server.Credentials(r.Context())
+	if err != nil {
+		server.handleRetrieveError(w, err)
+		return
+	}
+	if r.PathValue("role") != roleNameFromArn(roleAndPrincipal.Role) {
+		http.NotFound(w, r)
+		return
+	}
+	server.mu.Lock()
+	lastUpdated := server.lastUpdated
+	server.mu.Unlock()
+	writeJson(w, imdsCredentialsResponse{
+		Code:            "Success",
+		LastUpdated:     lastUpdated.UTC(),
+		Type:            "AWS-HMAC",
+		AccessKeyId:     *credentials.AccessKeyId,
+		SecretAccessKey: *credentials.SecretAccessKey,
+		Token:           *credentials.SessionToken,
+		Expiration:      credentials.Expiration.UTC(),
+	})
+}
+
+func (server *CredentialServer) isValidImdsToken(r *http.Request) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	expiration, exists := server.imdsTokens[r.Header.Get("X-aws-ec2-metadata-token")]
+	return exists && expiration.After(time.Now())
+}
+
+func (server *CredentialServer) handleRetrieveError(w http.ResponseWriter, err error) {
+	logrus.Errorln("Failed to retrieve credentials.", err)
+	http.Error(w, "failed to retrieve credentials", http.StatusInternalServerError)
+}
+
+func writeJson(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logrus.Errorln("Failed to write credentials response.", err)
+	}
+}
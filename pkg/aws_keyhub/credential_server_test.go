@@ -0,0 +1,134 @@
+package aws_keyhub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// testCredentials are credentials for an hour, as returned by Retrieve.
+func testCredentials() *types.Credentials {
+	return &types.Credentials{
+		AccessKeyId:     aws.String("ASIAKEYHUBTEST"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}
+}
+
+func TestCredentialServerRetrievesOnce(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var retrievals atomic.Int32
+		release := make(chan struct{})
+		server := &CredentialServer{
+			AuthorizationToken: NewAuthorizationToken(),
+			EnableIMDS:         true,
+			Retrieve: func(ctx context.Context) (RolesAndPrincipals, *types.Credentials, error) {
+				retrievals.Add(1)
+				// Like a KeyHub authorization the user still has to complete.
+				<-release
+				return RolesAndPrincipals{Role: testRoles[0].RoleArn}, testCredentials(), nil
+			},
+		}
+
+		var wg sync.WaitGroup
+		for range 3 {
+			wg.Go(func() {
+				if _, _, err := server.Credentials(context.Background()); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+		synctest.Wait()
+
+		// Requests that do not need the credentials are not blocked by the retrieval.
+		request := httptest.NewRequest(http.MethodPut, "http://127.0.0.1:9911"+imdsTokenPath, nil)
+		request.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("IMDS token request returned %d", recorder.Code)
+		}
+
+		close(release)
+		wg.Wait()
+		if retrievals.Load() != 1 {
+			t.Errorf("retrieved credentials %d times, want 1", retrievals.Load())
+		}
+	})
+}
+
+func TestCredentialServerCancelledRequest(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		release := make(chan struct{})
+		server := &CredentialServer{
+			AuthorizationToken: NewAuthorizationToken(),
+			Retrieve: func(ctx context.Context) (RolesAndPrincipals, *types.Credentials, error) {
+				select {
+				case <-release:
+					return RolesAndPrincipals{Role: testRoles[0].RoleArn}, testCredentials(), nil
+				case <-ctx.Done():
+					return RolesAndPrincipals{}, nil, ctx.Err()
+				}
+			},
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		firstDone := make(chan error)
+		go func() {
+			_, _, err := server.Credentials(ctx)
+			firstDone <- err
+		}()
+		secondDone := make(chan error)
+		go func() {
+			_, _, err := server.Credentials(context.Background())
+			secondDone <- err
+		}()
+		synctest.Wait()
+
+		// The client of the first request disconnects.
+		cancel()
+		if err := <-firstDone; err == nil {
+			t.Error("cancelled request returned credentials")
+		}
+		close(release)
+		if err := <-secondDone; err != nil {
+			t.Errorf("retrieval cancelled for the other request: %v", err)
+		}
+	})
+}
+
+func TestCredentialServerRejectsBrowserRequests(t *testing.T) {
+	server := &CredentialServer{AuthorizationToken: NewAuthorizationToken(), EnableIMDS: true}
+	for _, test := range []struct {
+		host   string
+		origin string
+		want   int
+	}{
+		{host: "127.0.0.1:9911", want: http.StatusOK},
+		{host: "[::1]:9911", want: http.StatusOK},
+		{host: "localhost:9911", want: http.StatusOK},
+		{host: "attacker.example.com:9911", want: http.StatusForbidden},
+		{host: "127.0.0.1:9911", origin: "http://attacker.example.com", want: http.StatusForbidden},
+	} {
+		request := httptest.NewRequest(http.MethodPut, imdsTokenPath, nil)
+		request.Host = test.host
+		request.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+		if test.origin != "" {
+			request.Header.Set("Origin", test.origin)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		if recorder.Code != test.want {
+			t.Errorf("IMDS token request for host %s with origin %q returned %d, want %d", test.host, test.origin, recorder.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package aws_keyhub
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// qrQuietZone is the number of light modules around the QR code, scanners need it to find the code.
+const qrQuietZone = 2
+
+// IsHeadless reports whether aws-keyhub runs without a browser the user can see, for example in an SSH session or on
+// a Linux machine without a graphical desktop.
+func IsHeadless() bool {
+	if os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != "" {
+		return true
+	}
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return false
+	}
+	return os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == ""
+}
+
+// WriteDeviceCodeInstructions writes the verification URI and user code of the device authorization to w, together
+// with a QR code of the complete verification URI, so the user can authorize aws-keyhub on another device.
+func WriteDeviceCodeInstructions(w io.Writer, authorizeDeviceResponse AuthorizeDeviceResponse) error {
+	completeUri := authorizeDeviceResponse.VerificationUriComplete
+	if completeUri == "" {
+		completeUri = authorizeDeviceResponse.VerificationUri
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "To authorize aws-keyhub, visit %s and enter the code %s\n", authorizeDeviceResponse.VerificationUri, authorizeDeviceResponse.UserCode)
+	fmt.Fprintf(&builder, "or open %s on your phone by scanning the QR code:\n\n", completeUri)
+	if err := writeQRCode(&builder, completeUri); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// writeQRCode writes the text as a QR code of Unicode half blocks, every line of characters shows two rows of
+// modules. The light modules are drawn, so the code has the expected colors on a terminal with a dark background.
+func writeQRCode(w io.Writer, text string) error {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return fmt.Errorf("failed to create QR code: %w", err)
+	}
+	light := func(x, y int) bool {
+		return !code.Black(x, y)
+	}
+	var builder strings.Builder
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y += 2 {
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			upper, lower := light(x, y), light(x, y+1) && y+1 < code.Size+qrQuietZone
+			switch {
+			case upper && lower:
+				builder.WriteRune('█')
+			case upper:
+				builder.WriteRune('▀')
+			case lower:
+				builder.WriteRune('▄')
+			default:
+				builder.WriteRune(' ')
+			}
+		}
+		builder.WriteByte('\n')
+	}
+	_, err = io.WriteString(w, builder.String())
+	return err
+}
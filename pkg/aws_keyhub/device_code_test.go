@@ -0,0 +1,49 @@
+package aws_keyhub
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWriteQRCode(t *testing.T) {
+	var output strings.Builder
+	if err := writeQRCode(&output, "https://keyhub.test/device?user_code=ABCD-EFGH"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	// A version 3 QR code has 29 modules per side, plus the quiet zone, two rows of modules per line.
+	width := 29 + 2*qrQuietZone
+	if len(lines) != (width+1)/2 {
+		t.Errorf("QR code has %d lines, want %d", len(lines), (width+1)/2)
+	}
+	for _, line := range lines {
+		if length := len([]rune(line)); length != width {
+			t.Fatalf("QR code line %q has %d characters, want %d", line, length, width)
+		}
+	}
+	if lines[0] != strings.Repeat("█", width) {
+		t.Errorf("QR code does not start with the quiet zone: %q", lines[0])
+	}
+}
+
+func TestIsHeadless(t *testing.T) {
+	t.Setenv("SSH_TTY", "")
+	t.Setenv("SSH_CONNECTION", "192.0.2.1 50000 192.0.2.2 22")
+	if !IsHeadless() {
+		t.Error("not headless in an SSH session")
+	}
+
+	t.Setenv("SSH_CONNECTION", "")
+	t.Setenv("DISPLAY", "")
+	t.Setenv("WAYLAND_DISPLAY", "")
+	if headless := IsHeadless(); headless != (runtime.GOOS != "windows" && runtime.GOOS != "darwin") {
+		t.Errorf("IsHeadless() = %v without a display on %s", headless, runtime.GOOS)
+	}
+
+	t.Setenv("DISPLAY", ":0")
+	if IsHeadless() {
+		t.Error("headless with a display")
+	}
+}
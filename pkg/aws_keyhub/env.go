@@ -0,0 +1,83 @@
+package aws_keyhub
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+const (
+	ShellBash       = "bash" // Also used for zsh and other POSIX shells.
+	ShellFish       = "fish"
+	ShellPowerShell = "powershell"
+	ShellCmd        = "cmd"
+)
+
+var Shells = []string{ShellBash, ShellFish, ShellPowerShell, ShellCmd}
+
+// DetectShell makes a best-effort guess of the shell aws-keyhub is started from.
+func DetectShell() string {
+	if runtime.GOOS == "windows" {
+		// cmd.exe sets PROMPT for its child processes, PowerShell does not.
+		if os.Getenv("PROMPT") != "" {
+			return ShellCmd
+		}
+		return ShellPowerShell
+	}
+	switch shell := strings.TrimSuffix(filepath.Base(os.Getenv("SHELL")), ".exe"); shell {
+	case "fish":
+		return ShellFish
+	case "pwsh", "powershell":
+		return ShellPowerShell
+	}
+	return ShellBash
+}
+
+// CredentialEnvironmentVariables returns the environment variables the AWS CLI and SDKs read credentials from, in a stable order.
+func CredentialEnvironmentVariables(credentials *types.Credentials, region string) [][2]string {
+	variables := [][2]string{
+		{"AWS_ACCESS_KEY_ID", *credentials.AccessKeyId},
+		{"AWS_SECRET_ACCESS_KEY", *credentials.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", *credentials.SessionToken},
+	}
+	if credentials.Expiration != nil {
+		variables = append(variables, [2]string{"AWS_CREDENTIAL_EXPIRATION", credentials.Expiration.UTC().Format(time.RFC3339)})
+	}
+	if region != "" {
+		variables = append(variables, [2]string{"AWS_REGION", region}, [2]string{"AWS_DEFAULT_REGION", region})
+	}
+	return variables
+}
+
+func WriteEnvironmentVariables(writer io.Writer, shell string, credentials *types.Credentials, region string) error {
+	return WriteVariables(writer, shell, CredentialEnvironmentVariables(credentials, region))
+}
+
+// WriteVariables writes the commands that set the environment variables in the syntax of the shell.
+func WriteVariables(writer io.Writer, shell string, variables [][2]string) error {
+	for _, variable := range variables {
+		var line string
+		switch shell {
+		case ShellBash:
+			line = fmt.Sprintf("export %s='%s'", variable[0], strings.ReplaceAll(variable[1], "'", `'"'"'`))
+		case ShellFish:
+			line = fmt.Sprintf("set -gx %s '%s';", variable[0], strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(variable[1]))
+		case ShellPowerShell:
+			line = fmt.Sprintf("$env:%s = '%s'", variable[0], strings.ReplaceAll(variable[1], "'", "''"))
+		case ShellCmd:
+			line = fmt.Sprintf(`set "%s=%s"`, variable[0], variable[1])
+		default:
+			return fmt.Errorf("unsupported shell '%s', supported shells are: %s", shell, strings.Join(Shells, ", "))
+		}
+		if _, err := fmt.Fprintln(writer, line); err != nil {
+			return fmt.Errorf("failed to write environment variables: %w", err)
+		}
+	}
+	return nil
+}
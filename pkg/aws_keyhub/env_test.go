@@ -0,0 +1,57 @@
+package aws_keyhub
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// awkwardValue contains the characters the shells treat specially inside and outside of quotes.
+const awkwardValue = `it's a "$HOME" \value`
+
+func TestWriteVariables(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{ShellBash, `export AWS_SECRET_ACCESS_KEY='it'"'"'s a "$HOME" \value'` + "\n"},
+		{ShellFish, `set -gx AWS_SECRET_ACCESS_KEY 'it\'s a "$HOME" \\value';` + "\n"},
+		{ShellPowerShell, `$env:AWS_SECRET_ACCESS_KEY = 'it''s a "$HOME" \value'` + "\n"},
+		{ShellCmd, `set "AWS_SECRET_ACCESS_KEY=it's a "$HOME" \value"` + "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.shell, func(t *testing.T) {
+			var output strings.Builder
+			if err := WriteVariables(&output, test.shell, [][2]string{{"AWS_SECRET_ACCESS_KEY", awkwardValue}}); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != test.want {
+				t.Errorf("wrote %s, want %s", output.String(), test.want)
+			}
+		})
+	}
+
+	if err := WriteVariables(&strings.Builder{}, "tcsh", [][2]string{{"AWS_SECRET_ACCESS_KEY", awkwardValue}}); err == nil {
+		t.Error("expected an error for an unsupported shell")
+	}
+}
+
+func TestWriteVariablesBash(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not available")
+	}
+	var script strings.Builder
+	if err := WriteVariables(&script, ShellBash, [][2]string{{"AWS_SECRET_ACCESS_KEY", awkwardValue}}); err != nil {
+		t.Fatal(err)
+	}
+	script.WriteString(`printf %s "$AWS_SECRET_ACCESS_KEY"`)
+
+	output, err := exec.Command(bash, "-c", script.String()).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != awkwardValue {
+		t.Errorf("bash set %q, want %q", output, awkwardValue)
+	}
+}
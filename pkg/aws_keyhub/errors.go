@@ -0,0 +1,108 @@
+package aws_keyhub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrAuthorizationRequired is returned when KeyHub cannot be used without the user authorizing aws-keyhub (again),
+// for example because the refresh token expired.
+var ErrAuthorizationRequired = errors.New("KeyHub authorization required, please run `aws-keyhub login`")
+
+// ErrAuthorizationTimeout is returned when the user did not authorize aws-keyhub before the device code expired.
+var ErrAuthorizationTimeout = errors.New("KeyHub login failed, authorization request was not accepted in a timely manner")
+
+// ErrAuthorizationDenied is returned when the user denied the authorization request of aws-keyhub.
+var ErrAuthorizationDenied = errors.New("KeyHub login failed, authorization request was denied")
+
+// ErrRoleRequired is returned when no role is selected and aws-keyhub cannot prompt for one.
+var ErrRoleRequired = errors.New("no role selected, select one with --role-arn, --account, --role-name or --role")
+
+// ConfigNotFoundError is returned when the aws-keyhub configuration file of a context does not exist, or when
+// configuring it without prompting lacks required settings.
+type ConfigNotFoundError struct {
+	Context         string
+	MissingSettings []string // The required settings that were not given, if any.
+}
+
+func (e *ConfigNotFoundError) Error() string {
+	if len(e.MissingSettings) > 0 {
+		return fmt.Sprintf("cannot configure aws-keyhub without the %s", strings.Join(e.MissingSettings, ", "))
+	}
+	if e.Context != DefaultContext {
+		return fmt.Sprintf("no aws-keyhub configuration file for context '%[1]s', please run `aws-keyhub configure --context %[1]s` first", e.Context)
+	}
+	return "no aws-keyhub configuration file, please run `aws-keyhub configure` first"
+}
+
+// AwsConfigNotFoundError is returned when the AWS CLI configuration file does not exist.
+type AwsConfigNotFoundError struct {
+	Path string
+}
+
+func (e *AwsConfigNotFoundError) Error() string {
+	return fmt.Sprintf("no AWS configuration file at %s, please run `aws configure` first, you can leave the access key fields empty", e.Path)
+}
+
+// KeyhubError is returned when KeyHub responds with an error or an unexpected HTTP status code.
+type KeyhubError struct {
+	StatusCode       int
+	ErrorCode        string // The OAuth2 error code, e.g. access_denied.
+	ErrorDescription string
+	Body             string // The response body when it is not an OAuth2 error response.
+}
+
+func (e *KeyhubError) Error() string {
+	if e.ErrorCode != "" {
+		return fmt.Sprintf("KeyHub returned error %s: %s", e.ErrorCode, e.ErrorDescription)
+	}
+	return fmt.Sprintf("KeyHub returned unexpected HTTP status code %d: %s", e.StatusCode, e.Body)
+}
+
+// RoleNotFoundError is returned when a role is not in the SAML assertion received from KeyHub.
+type RoleNotFoundError struct {
+	RoleArn string
+}
+
+func (e *RoleNotFoundError) Error() string {
+	return fmt.Sprintf("role %s is not available in the SAML assertion received from KeyHub", e.RoleArn)
+}
+
+// NoMatchingRoleError is returned when no role in the SAML assertion received from KeyHub matches the role selector.
+type NoMatchingRoleError struct {
+	Selector RoleSelector
+}
+
+func (e *NoMatchingRoleError) Error() string {
+	return fmt.Sprintf("no role in the SAML assertion received from KeyHub matches %s", e.Selector)
+}
+
+// AmbiguousRoleError is returned when more than one role matches the role selector and aws-keyhub cannot prompt for
+// the role.
+type AmbiguousRoleError struct {
+	Selector RoleSelector
+	RoleArns []string
+}
+
+func (e *AmbiguousRoleError) Error() string {
+	return fmt.Sprintf("%d roles match %s, select one of them with --role-arn: %s", len(e.RoleArns), e.Selector, strings.Join(e.RoleArns, ", "))
+}
+
+// StsError is returned when a call to AWS STS fails.
+type StsError struct {
+	Operation string
+	RoleArn   string
+	Err       error
+}
+
+func (e *StsError) Error() string {
+	if e.RoleArn == "" {
+		return fmt.Sprintf("AWS STS %s failed: %s", e.Operation, e.Err)
+	}
+	return fmt.Sprintf("AWS STS %s for role %s failed: %s", e.Operation, e.RoleArn, e.Err)
+}
+
+func (e *StsError) Unwrap() error {
+	return e.Err
+}
@@ -1,25 +1,31 @@
 package aws_keyhub
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"sync"
+	"strings"
 	"time"
 
-	"github.com/cli/browser"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/sirupsen/logrus"
 )
 
-const MaxPollAttempts = 24
 const RefreshTokenClockSkewSeconds = 30
 
-var doOnceHTTPClient sync.Once
-var httpClient http.Client
+// DefaultPollInterval is the interval between polls for the access token when KeyHub does not specify one, see RFC 8628 section 3.2.
+const DefaultPollInterval = 5 * time.Second
+
+// SlowDownIncrement is added to the poll interval every time KeyHub responds with slow_down, see RFC 8628 section 3.5.
+const SlowDownIncrement = 5 * time.Second
+
+// DefaultDeviceCodeLifetime is used when KeyHub does not specify how long the device code is valid.
+const DefaultDeviceCodeLifetime = 5 * time.Minute
 
 type AuthorizeDeviceResponse struct {
 	UserCode                string `json:"user_code"`
@@ -49,187 +55,321 @@ type TokenExchangeResponse struct {
 	ExpiresIn       int     `json:"expires_in"`
 }
 
-func getHTTPClient() http.Client {
-	config := getAwsKeyHubConfig()
-	doOnceHTTPClient.Do(func() {
-		logrus.Debugln("Initializing HTTP Client for further usage.")
-		httpClient = http.Client{Timeout: time.Duration(20) * time.Second}
-		if config.Keyhub.AllowInsecureTLS {
-			http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		}
-	})
-
+func newHTTPClient(config KeyhubConfigFile) *http.Client {
+	logrus.Debugln("Initializing HTTP Client for further usage.")
+	httpClient := &http.Client{Timeout: time.Duration(20) * time.Second}
+	if config.Keyhub.AllowInsecureTLS {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		httpClient.Transport = transport
+	}
 	return httpClient
 }
 
-func authorizeDevice() AuthorizeDeviceResponse {
-	config := getAwsKeyHubConfig()
-	httpClient := getHTTPClient()
-
+func (client *Client) authorizeDevice(ctx context.Context) (AuthorizeDeviceResponse, error) {
+	var result AuthorizeDeviceResponse
 	authorizeDevicePath := "/login/oauth2/authorizedevice"
 	data := url.Values{
-		"resource":  {config.Keyhub.AwsSamlClientId},
+		"resource":  {client.Config.Keyhub.AwsSamlClientId},
 		"scope":     {"profile"},
-		"client_id": {config.Keyhub.ClientId},
+		"client_id": {client.Config.Keyhub.ClientId},
 	}
 	logrus.Debugln("KeyHub authorize device POST formdata: ", data)
-	resp, err := httpClient.PostForm(config.Keyhub.Url+authorizeDevicePath, data)
+	resp, err := client.postForm(ctx, authorizeDevicePath, data)
 	if err != nil {
-		logrus.Fatal("Failed to post form data to KeyHub authorize device endpoint.", err)
+		return result, fmt.Errorf("failed to post form data to KeyHub authorize device endpoint: %w", err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		handleUnexpectedResponseCodeResponse(resp)
+		return result, handleUnexpectedResponseCodeResponse(resp)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		logrus.Fatal("Failed to read KeyHub authorize device response body", err)
+		return result, fmt.Errorf("failed to read KeyHub authorize device response body: %w", err)
 	}
 	logrus.Debugln("KeyHub authorize device response body:", string(body))
 
-	var result AuthorizeDeviceResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		logrus.Fatal("Device authorization failed; could not unmarshal JSON")
+		return result, fmt.Errorf("device authorization failed; could not unmarshal JSON: %w", err)
 	}
 
 	logrus.Debugln("KeyHub authorize device received confirmation code:", result.UserCode)
-	browser.OpenURL(result.VerificationUriComplete)
-	logrus.Infoln("If your browser did not open, please visit this url:", result.VerificationUriComplete)
+	if client.openBrowser != nil {
+		err := client.openBrowser(result.VerificationUriComplete)
+		if err == nil {
+			logrus.Infoln("If your browser did not open, please visit this url:", result.VerificationUriComplete)
+			return result, nil
+		}
+		logrus.Debugln("Failed to open the browser, showing the device code instead:", err)
+	}
+	return result, WriteDeviceCodeInstructions(client.deviceCodeOutput, result)
+}
 
-	return result
+// DoLogin logs in to KeyHub with the stored refresh token, or otherwise lets the user authorize aws-keyhub with the
+// configured grant type. The device authorization flow is used when the authorization code flow needs a browser that
+// is not available. A client created WithoutAuthorization returns ErrAuthorizationRequired instead.
+func (client *Client) DoLogin(ctx context.Context) (TokenExchangeResponse, error) {
+	tokenExchangeResponse, err := client.DoLoginWithRefreshToken(ctx)
+	if !errors.Is(err, ErrAuthorizationRequired) || client.noAuthorization {
+		return tokenExchangeResponse, err
+	}
+	switch client.Config.Keyhub.GrantType {
+	case "", GrantTypeDeviceCode:
+	case GrantTypeAuthorizationCode:
+		tokenExchangeResponse, err := client.loginWithAuthorizationCode(ctx)
+		if !errors.Is(err, errBrowserUnavailable) {
+			return tokenExchangeResponse, err
+		}
+		logrus.Infoln("No browser available, using the device authorization flow instead.")
+	default:
+		return TokenExchangeResponse{}, fmt.Errorf("unsupported KeyHub grant type %q, use one of %s", client.Config.Keyhub.GrantType, strings.Join(GrantTypes, ", "))
+	}
+	authorizeDeviceResponse, err := client.authorizeDevice(ctx)
+	if err != nil {
+		return TokenExchangeResponse{}, err
+	}
+	return client.pollForAccessToken(ctx, authorizeDeviceResponse)
 }
 
-func DoLogin() TokenExchangeResponse {
-	refreshTokenFile := readRefreshToken()
-	var tokenExchangeResponse *TokenExchangeResponse
-	if refreshTokenFile != nil && isAccessTokenValid(*refreshTokenFile) {
-		logrus.Infoln("Attempting KeyHub login using refresh token.")
-		tokenExchangeResponse = getAccessTokenWithRefreshToken(*refreshTokenFile)
-	} else if refreshTokenFile != nil {
-		removeInvalidOrExpiredRefreshTokenFile()
+// DoLoginWithRefreshToken logs in using the stored refresh token only. It returns ErrAuthorizationRequired when there
+// is no valid refresh token, in which case the user has to authorize aws-keyhub again.
+func (client *Client) DoLoginWithRefreshToken(ctx context.Context) (TokenExchangeResponse, error) {
+	refreshTokenFile, err := client.readRefreshToken()
+	if err != nil {
+		return TokenExchangeResponse{}, err
+	}
+	if refreshTokenFile == nil {
+		return TokenExchangeResponse{}, ErrAuthorizationRequired
+	}
+	if !isAccessTokenValid(*refreshTokenFile) {
+		client.removeInvalidOrExpiredRefreshToken()
+		return TokenExchangeResponse{}, ErrAuthorizationRequired
 	}
 
+	logrus.Infoln("Attempting KeyHub login using refresh token.")
+	tokenExchangeResponse, err := client.getAccessTokenWithRefreshToken(ctx, *refreshTokenFile)
 	logrus.Debugln("TokenExchangeResponse from refresh token:", tokenExchangeResponse)
-
-	if tokenExchangeResponse != nil {
-		return *tokenExchangeResponse
-	}
-	authorizeDeviceResponse := authorizeDevice()
-	return pollForAccessToken(authorizeDeviceResponse, 0)
+	return tokenExchangeResponse, err
 }
 
-func pollForAccessToken(authorizeDeviceresponse AuthorizeDeviceResponse, noOfTimesPolled int) TokenExchangeResponse {
-	noOfTimesPolled++
-	if noOfTimesPolled > MaxPollAttempts {
-		logrus.Fatal("Keyhub login failed. Authorization request was not accepted in a timely manner.")
+// pollForAccessToken polls KeyHub until the user authorized aws-keyhub, the device code expired or the context is done,
+// as described in RFC 8628 section 3.4 and 3.5.
+func (client *Client) pollForAccessToken(ctx context.Context, authorizeDeviceResponse AuthorizeDeviceResponse) (TokenExchangeResponse, error) {
+	interval := time.Duration(authorizeDeviceResponse.Interval) * time.Second
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	lifetime := time.Duration(authorizeDeviceResponse.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = DefaultDeviceCodeLifetime
 	}
-	config := getAwsKeyHubConfig()
+	deadline := time.Now().Add(lifetime)
 
 	data := url.Values{
 		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
-		"device_code": {authorizeDeviceresponse.DeviceCode},
-		"client_id":   {config.Keyhub.ClientId},
+		"device_code": {authorizeDeviceResponse.DeviceCode},
+		"client_id":   {client.Config.Keyhub.ClientId},
 	}
-	resp := submitTokenExchange(data)
+	for {
+		if time.Now().Add(interval).After(deadline) {
+			return TokenExchangeResponse{}, ErrAuthorizationTimeout
+		}
+		logrus.Debugf("Waiting %s for user to authorize device...", interval)
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return TokenExchangeResponse{}, fmt.Errorf("KeyHub login aborted: %w", ctx.Err())
+		case <-timer.C:
+		}
+
+		tokenExchangeResponse, errorResponse, err := client.pollOnce(ctx, data)
+		if err != nil {
+			return TokenExchangeResponse{}, err
+		}
+		if errorResponse == nil {
+			return tokenExchangeResponse, nil
+		}
+		switch errorResponse.ErrorCode {
+		case "authorization_pending":
+		case "slow_down":
+			interval += SlowDownIncrement
+			logrus.Debugln("KeyHub requested to slow down polling, interval is now", interval)
+		case "access_denied":
+			return TokenExchangeResponse{}, fmt.Errorf("%w: %w", ErrAuthorizationDenied, errorResponse)
+		case "expired_token":
+			return TokenExchangeResponse{}, fmt.Errorf("%w: %w", ErrAuthorizationTimeout, errorResponse)
+		default:
+			return TokenExchangeResponse{}, errorResponse
+		}
+	}
+}
+
+// pollOnce requests the access token for the device code. The OAuth2 error response is returned when the user has not
+// authorized aws-keyhub (yet).
+func (client *Client) pollOnce(ctx context.Context, data url.Values) (TokenExchangeResponse, *KeyhubError, error) {
+	resp, err := client.submitTokenExchange(ctx, data)
+	if err != nil {
+		return TokenExchangeResponse{}, nil, err
+	}
+	defer resp.Body.Close()
 
 	switch resp.StatusCode {
 	case 200:
-		return handleTokenExchangeSuccessResponse(resp, true)
+		tokenExchangeResponse, err := client.handleTokenExchangeSuccessResponse(resp, true)
+		return tokenExchangeResponse, nil, err
 	case 400:
-		errorResponse := handleErrorResponse(resp)
-		if errorResponse.Error == "authorization_pending" {
-			logrus.Debugf("Waiting %d seconds for user to authorize device...", authorizeDeviceresponse.Interval)
-			time.Sleep(time.Duration(authorizeDeviceresponse.Interval) * time.Second)
-			return pollForAccessToken(authorizeDeviceresponse, noOfTimesPolled)
-		} else {
-			logrus.Fatalf("KeyHub login failed: %s", errorResponse.ErrorDescription)
-		}
+		return TokenExchangeResponse{}, handleErrorResponse(resp), nil
 	default:
-		handleUnexpectedResponseCodeResponse(resp)
+		return TokenExchangeResponse{}, nil, handleUnexpectedResponseCodeResponse(resp)
 	}
-	return TokenExchangeResponse{}
 }
 
-func getAccessTokenWithRefreshToken(refreshTokenFile RefreshTokenFile) *TokenExchangeResponse {
-	config := getAwsKeyHubConfig()
-
+func (client *Client) getAccessTokenWithRefreshToken(ctx context.Context, refreshTokenFile RefreshTokenFile) (TokenExchangeResponse, error) {
 	data := url.Values{
 		"grant_type":    {"refresh_token"},
 		"refresh_token": {refreshTokenFile.RefreshToken},
-		"client_id":     {config.Keyhub.ClientId},
+		"client_id":     {client.Config.Keyhub.ClientId},
+	}
+	resp, err := client.submitTokenExchange(ctx, data)
+	if err != nil {
+		return TokenExchangeResponse{}, err
 	}
-	resp := submitTokenExchange(data)
+	defer resp.Body.Close()
 
 	switch resp.StatusCode {
 	case 200:
 		logrus.Infoln("KeyHub login using token refresh successful.")
-		resp := handleTokenExchangeSuccessResponse(resp, true)
-		return &resp
+		return client.handleTokenExchangeSuccessResponse(resp, true)
 	case 400:
-		defer removeInvalidOrExpiredRefreshTokenFile()
 		errorResponse := handleErrorResponse(resp)
+		// Only a rejected refresh token is removed, other errors leave it for the next attempt.
+		if errorResponse.ErrorCode != "invalid_grant" && errorResponse.ErrorCode != "invalid_token" {
+			return TokenExchangeResponse{}, errorResponse
+		}
+		defer client.removeInvalidOrExpiredRefreshToken()
 		logrus.Errorf("KeyHub token refresh failed: %s", errorResponse.ErrorDescription)
-		return nil
+		return TokenExchangeResponse{}, ErrAuthorizationRequired
 	default:
-		defer removeInvalidOrExpiredRefreshTokenFile()
-		handleUnexpectedResponseCodeResponse(resp)
+		return TokenExchangeResponse{}, handleUnexpectedResponseCodeResponse(resp)
 	}
-	return nil
 }
 
-func ExchangeToken(tokenExchangeResponse TokenExchangeResponse) TokenExchangeResponse {
-	config := getAwsKeyHubConfig()
+func (client *Client) ExchangeToken(ctx context.Context, tokenExchangeResponse TokenExchangeResponse) (TokenExchangeResponse, error) {
 	data := url.Values{
 		"grant_type":           {"urn:ietf:params:oauth:grant-type:token-exchange"},
 		"subject_token_type":   {"urn:ietf:params:oauth:token-type:access_token"},
 		"subject_token":        {tokenExchangeResponse.AccessToken},
 		"requested_token_type": {"urn:ietf:params:oauth:token-type:saml2"},
-		"resource":             {config.Keyhub.AwsSamlClientId},
-		"client_id":            {config.Keyhub.ClientId},
+		"resource":             {client.Config.Keyhub.AwsSamlClientId},
+		"client_id":            {client.Config.Keyhub.ClientId},
+	}
+	resp, err := client.submitTokenExchange(ctx, data)
+	if err != nil {
+		return TokenExchangeResponse{}, err
 	}
-	resp := submitTokenExchange(data)
+	defer resp.Body.Close()
 
 	switch resp.StatusCode {
 	case 200:
 		logrus.Infoln("KeyHub token exchange successful.")
-		return handleTokenExchangeSuccessResponse(resp, false)
+		return client.handleTokenExchangeSuccessResponse(resp, false)
 	default:
-		handleUnexpectedResponseCodeResponse(resp)
+		return TokenExchangeResponse{}, handleUnexpectedResponseCodeResponse(resp)
 	}
-	return TokenExchangeResponse{}
 }
 
-func submitTokenExchange(data url.Values) *http.Response {
-	httpClient := getHTTPClient()
-	config := getAwsKeyHubConfig()
-
+func (client *Client) submitTokenExchange(ctx context.Context, data url.Values) (*http.Response, error) {
 	tokenPath := "/login/oauth2/token"
 	logrus.Debugln("KeyHub token exchange POST formdata: ", data)
-	resp, err := httpClient.PostForm(config.Keyhub.Url+tokenPath, data)
+	resp, err := client.postForm(ctx, tokenPath, data)
 	if err != nil {
-		logrus.Fatal("Failed to post form data to KeyHub token endpoint.", err)
+		return nil, fmt.Errorf("failed to post form data to KeyHub token endpoint: %w", err)
 	}
 	logrus.Debugln("KeyHub token exchange response:", resp)
-	return resp
+	return resp, nil
+}
+
+func (client *Client) postForm(ctx context.Context, path string, data url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Config.Keyhub.Url+path, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return client.httpClient.Do(req)
 }
 
-func readRefreshToken() *RefreshTokenFile {
-	filePath := GetAwsKeyHubRefreshTokenPath()
-	file, err := os.Open(filePath)
+// readRefreshToken returns the stored refresh token, or nil when there is none. A refresh token file written before
+// another secret store was configured is moved to that secret store.
+func (client *Client) readRefreshToken() (*RefreshTokenFile, error) {
+	secretStore, err := client.getSecretStore()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := secretStore.Load(client.contextName)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		if secret, err = client.migrateRefreshTokenFile(secretStore); err != nil || secret == nil {
+			return nil, err
+		}
+	}
+	return decodeRefreshToken(secret)
+}
+
+// peekRefreshToken returns the stored refresh token like readRefreshToken, but without changing anything: a refresh
+// token file is not moved to the secret store and the passphrase of an encrypted file is never asked for.
+func (client *Client) peekRefreshToken() (*RefreshTokenFile, error) {
+	secretStore, err := client.getSecretStore()
+	if err != nil {
+		return nil, err
+	}
+	if encryptedFile, ok := secretStore.(*encryptedFileSecretStore); ok {
+		secretStore = encryptedFile.withoutPrompt()
+	}
+	secret, err := secretStore.Load(client.contextName)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	defer file.Close()
+	if secret == nil {
+		if secret, err = (fileSecretStore{}).Load(client.contextName); err != nil || secret == nil {
+			return nil, err
+		}
+	}
+	return decodeRefreshToken(secret)
+}
 
+func decodeRefreshToken(secret []byte) (*RefreshTokenFile, error) {
 	var refreshTokenFile RefreshTokenFile
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&refreshTokenFile); err != nil {
-		logrus.Fatal("Error decoding refresh-token.json file:", err)
+	if err := json.Unmarshal(secret, &refreshTokenFile); err != nil {
+		return nil, fmt.Errorf("error decoding stored refresh token: %w", err)
 	}
-	return &refreshTokenFile
+	return &refreshTokenFile, nil
 }
 
+// migrateRefreshTokenFile moves the plain refresh token file to the secret store, when the secret store is not the
+// file itself. It returns the migrated refresh token, or nil when there is no refresh token file.
+func (client *Client) migrateRefreshTokenFile(secretStore SecretStore) ([]byte, error) {
+	if _, isFile := secretStore.(fileSecretStore); isFile {
+		return nil, nil
+	}
+	var plainFile fileSecretStore
+	secret, err := plainFile.Load(client.contextName)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	logrus.Infoln("Moving the KeyHub refresh token to the", client.Config.Keyhub.SecretStore, "secret store.")
+	if err := secretStore.Store(client.contextName, secret); err != nil {
+		return nil, err
+	}
+	if err := plainFile.Delete(client.contextName); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
 func isAccessTokenValid(refreshTokenFile RefreshTokenFile) bool {
 	if refreshTokenFile.RefreshToken == "" {
 		return false
@@ -239,34 +379,34 @@ func isAccessTokenValid(refreshTokenFile RefreshTokenFile) bool {
 
 	return true
 }
-func handleTokenExchangeSuccessResponse(resp *http.Response, shouldStoreRefreshToken bool) TokenExchangeResponse {
+func (client *Client) handleTokenExchangeSuccessResponse(resp *http.Response, shouldStoreRefreshToken bool) (TokenExchangeResponse, error) {
+	var result TokenExchangeResponse
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		logrus.Fatal("Failed to read KeyHub token exchange response body", err)
+		return result, fmt.Errorf("failed to read KeyHub token exchange response body: %w", err)
 	}
 	logrus.Debugln("KeyHub token exchange response body:", string(body))
 
-	var result TokenExchangeResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		logrus.Fatal("KeyHub token exchange failed; could not unmarshal JSON")
+		return result, fmt.Errorf("KeyHub token exchange failed; could not unmarshal JSON: %w", err)
 	}
 
 	if shouldStoreRefreshToken && result.RefreshToken != nil {
-		storeRefreshToken(result)
+		if err := client.storeRefreshToken(result); err != nil {
+			return result, err
+		}
 	}
-	return result
+	return result, nil
 }
-
-func handleUnexpectedResponseCodeResponse(resp *http.Response) {
+func handleUnexpectedResponseCodeResponse(resp *http.Response) *KeyhubError {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		logrus.Errorln("Failed to read keyhub response body:", err)
 	}
-	logrus.Errorln("KeyHub returned unexpected HTTP status code", resp.StatusCode)
-	logrus.Fatal("Received HTTP response body:", string(body))
+	return &KeyhubError{StatusCode: resp.StatusCode, Body: string(body)}
 }
 
-func handleErrorResponse(resp *http.Response) *KeyhubErrorResponse {
+func handleErrorResponse(resp *http.Response) *KeyhubError {
 	var errorResponse KeyhubErrorResponse
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -275,32 +415,29 @@ func handleErrorResponse(resp *http.Response) *KeyhubErrorResponse {
 	if err := json.Unmarshal(body, &errorResponse); err != nil {
 		logrus.Errorln("Failed to unmarshal Keyhub error response:", err)
 	}
-	return &errorResponse
+	return &KeyhubError{
+		StatusCode:       resp.StatusCode,
+		ErrorCode:        errorResponse.Error,
+		ErrorDescription: errorResponse.ErrorDescription,
+		Body:             string(body),
+	}
 }
 
-func storeRefreshToken(tokenExchangeResponse TokenExchangeResponse) {
-	logrus.Debugln("Storing refresh token to file.")
-	filePath := GetAwsKeyHubRefreshTokenPath()
-	writeFile, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+func (client *Client) storeRefreshToken(tokenExchangeResponse TokenExchangeResponse) error {
+	logrus.Debugln("Storing refresh token.")
+	secretStore, err := client.getSecretStore()
 	if err != nil {
-		logrus.Fatalf("Error creating %s file: %s", filePath, err)
+		return err
 	}
-	defer writeFile.Close()
-
 	refreshTokenFile := RefreshTokenFile{
 		RefreshToken: *tokenExchangeResponse.RefreshToken,
 		ExpireDate:   determineRefreshTokenExpireDate(tokenExchangeResponse),
 	}
-
 	jsonBytes, err := json.Marshal(refreshTokenFile)
 	if err != nil {
-		logrus.Fatal("Error marshaling refresh token to JSON:", err)
+		return fmt.Errorf("error marshaling refresh token to JSON: %w", err)
 	}
-	_, err = writeFile.Write(jsonBytes)
-	if err != nil {
-		logrus.Fatalf("Error writing to %s file: %s", filePath, err)
-	}
-	logrus.Debugln("Wrote refresh token to file at", filePath)
+	return secretStore.Store(client.contextName, jsonBytes)
 }
 
 // there is no field (yet) for refresh token expiration in the token exchange response, this is a best-effort attempt to determine it
@@ -322,12 +459,14 @@ func determineRefreshTokenExpireDate(tokenExchangeResponse TokenExchangeResponse
 	return fallback
 }
 
-func removeInvalidOrExpiredRefreshTokenFile() {
-	filePath := GetAwsKeyHubRefreshTokenPath()
-	err := os.Remove(filePath)
+func (client *Client) removeInvalidOrExpiredRefreshToken() {
+	secretStore, err := client.getSecretStore()
+	if err == nil {
+		err = secretStore.Delete(client.contextName)
+	}
 	if err != nil {
-		logrus.Errorln("Error removing refresh token file:", err)
+		logrus.Errorln("Error removing refresh token:", err)
 	} else {
-		logrus.Debugln("Removed invalid or expired refresh token file.")
+		logrus.Debugln("Removed invalid or expired refresh token.")
 	}
 }
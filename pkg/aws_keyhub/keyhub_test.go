@@ -0,0 +1,241 @@
+package aws_keyhub
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+)
+
+func TestDoLoginDeviceFlow(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		keyhub.DeviceCodeErrors = []string{"authorization_pending", "authorization_pending"}
+		client := newTestClient(t, keyhub, nil)
+		var openedUrl string
+		client.openBrowser = func(url string) error {
+			openedUrl = url
+			return nil
+		}
+
+		start := time.Now()
+		if _, err := client.DoLogin(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if openedUrl != keyhub.URL+"/device?user_code="+keyhubtest.UserCode {
+			t.Errorf("opened %q in the browser", openedUrl)
+		}
+		if polls := keyhub.Requests("urn:ietf:params:oauth:grant-type:device_code"); polls != 3 {
+			t.Errorf("polled %d times, want 3", polls)
+		}
+		if elapsed := time.Since(start); elapsed != 3*DefaultPollInterval {
+			t.Errorf("polling took %s, want %s", elapsed, 3*DefaultPollInterval)
+		}
+
+		refreshTokenFile, err := client.readRefreshToken()
+		if err != nil || refreshTokenFile == nil {
+			t.Fatalf("no refresh token stored: %v", err)
+		}
+		if expiresIn := time.Until(refreshTokenFile.ExpireDate); expiresIn < 55*time.Minute || expiresIn > time.Hour {
+			t.Errorf("refresh token expires in %s, want the exp claim of the refresh token", expiresIn)
+		}
+	})
+}
+
+func TestDoLoginDeviceFlowSlowDown(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		keyhub.Interval = 2
+		keyhub.DeviceCodeErrors = []string{"slow_down", "authorization_pending", "slow_down"}
+		client := newTestClient(t, keyhub, nil)
+
+		start := time.Now()
+		if _, err := client.DoLogin(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// 2s before the first poll, 7s after the first slow_down and 12s after the second.
+		if elapsed, expected := time.Since(start), 2*time.Second+7*time.Second+7*time.Second+12*time.Second; elapsed != expected {
+			t.Errorf("polling took %s, want %s", elapsed, expected)
+		}
+	})
+}
+
+func TestDoLoginDeviceFlowErrors(t *testing.T) {
+	for errorCode, expected := range map[string]error{
+		"access_denied": ErrAuthorizationDenied,
+		"expired_token": ErrAuthorizationTimeout,
+	} {
+		t.Run(errorCode, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				keyhub := keyhubtest.NewKeyHub(testRoles...)
+				keyhub.DeviceCodeErrors = []string{"authorization_pending", errorCode}
+				client := newTestClient(t, keyhub, nil)
+
+				_, err := client.DoLogin(context.Background())
+				if !errors.Is(err, expected) {
+					t.Errorf("got %v, want %v", err, expected)
+				}
+				var keyhubError *KeyhubError
+				if !errors.As(err, &keyhubError) || keyhubError.ErrorCode != errorCode {
+					t.Errorf("got %v, want a KeyhubError with error code %s", err, errorCode)
+				}
+				if refreshTokenFile, _ := client.readRefreshToken(); refreshTokenFile != nil {
+					t.Error("refresh token stored after failed login")
+				}
+			})
+		})
+	}
+}
+
+func TestDoLoginDeviceCodeExpires(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		keyhub.ExpiresIn = 60
+		keyhub.DeviceCodeErrors = make([]string, 100)
+		for i := range keyhub.DeviceCodeErrors {
+			keyhub.DeviceCodeErrors[i] = "authorization_pending"
+		}
+		client := newTestClient(t, keyhub, nil)
+
+		start := time.Now()
+		_, err := client.DoLogin(context.Background())
+		if !errors.Is(err, ErrAuthorizationTimeout) {
+			t.Errorf("got %v, want ErrAuthorizationTimeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Minute {
+			t.Errorf("polled for %s, after the device code expired", elapsed)
+		}
+		if polls := keyhub.Requests("urn:ietf:params:oauth:grant-type:device_code"); polls != 12 {
+			t.Errorf("polled %d times, want 12", polls)
+		}
+	})
+}
+
+func TestDoLoginCanceled(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		keyhub.DeviceCodeErrors = []string{"authorization_pending", "authorization_pending", "authorization_pending"}
+		client := newTestClient(t, keyhub, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(12*time.Second, cancel)
+
+		_, err := client.DoLogin(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want context.Canceled", err)
+		}
+		if polls := keyhub.Requests("urn:ietf:params:oauth:grant-type:device_code"); polls != 2 {
+			t.Errorf("polled %d times, want 2", polls)
+		}
+	})
+}
+
+func TestDoLoginUsesRefreshToken(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		client := newTestClient(t, keyhub, nil)
+		ctx := context.Background()
+
+		if _, err := client.DoLogin(ctx); err != nil {
+			t.Fatal(err)
+		}
+		firstRefreshToken, _ := client.readRefreshToken()
+		if _, err := client.DoLogin(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if authorizations := keyhub.Requests("authorizedevice"); authorizations != 1 {
+			t.Errorf("authorized device %d times, want 1", authorizations)
+		}
+		if refreshes := keyhub.Requests("refresh_token"); refreshes != 1 {
+			t.Errorf("used refresh token %d times, want 1", refreshes)
+		}
+		if secondRefreshToken, _ := client.readRefreshToken(); secondRefreshToken.RefreshToken == firstRefreshToken.RefreshToken {
+			t.Error("rotated refresh token was not stored")
+		}
+	})
+}
+
+func TestDoLoginWithRefreshTokenRevoked(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		client := newTestClient(t, keyhub, nil)
+		ctx := context.Background()
+
+		if _, err := client.DoLoginWithRefreshToken(ctx); !errors.Is(err, ErrAuthorizationRequired) {
+			t.Fatalf("got %v without refresh token, want ErrAuthorizationRequired", err)
+		}
+		if _, err := client.DoLogin(ctx); err != nil {
+			t.Fatal(err)
+		}
+		keyhub.RevokeRefreshTokens()
+
+		if _, err := client.DoLoginWithRefreshToken(ctx); !errors.Is(err, ErrAuthorizationRequired) {
+			t.Errorf("got %v with revoked refresh token, want ErrAuthorizationRequired", err)
+		}
+		refreshTokenPath, _ := GetAwsKeyHubRefreshTokenPath()
+		if _, err := os.Stat(refreshTokenPath); !os.IsNotExist(err) {
+			t.Error("revoked refresh token was not removed")
+		}
+	})
+}
+
+func TestDoLoginWithRefreshTokenExpired(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		keyhub.RefreshTokenLifetime = time.Minute
+		client := newTestClient(t, keyhub, nil)
+		ctx := context.Background()
+
+		if _, err := client.DoLogin(ctx); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Minute)
+		if _, err := client.DoLoginWithRefreshToken(ctx); !errors.Is(err, ErrAuthorizationRequired) {
+			t.Errorf("got %v with expired refresh token, want ErrAuthorizationRequired", err)
+		}
+		if refreshes := keyhub.Requests("refresh_token"); refreshes != 0 {
+			t.Errorf("used expired refresh token %d times", refreshes)
+		}
+	})
+}
+
+func TestDoLoginWithoutBrowser(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		client := newTestClient(t, keyhub, nil)
+		var output strings.Builder
+		WithoutBrowser()(client)
+		WithDeviceCodeOutput(&output)(client)
+
+		if _, err := client.DoLogin(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []string{keyhub.URL + "/device ", keyhubtest.UserCode, keyhub.URL + "/device?user_code=" + keyhubtest.UserCode, "▀"} {
+			if !strings.Contains(output.String(), expected) {
+				t.Errorf("device code instructions %q do not contain %q", output.String(), expected)
+			}
+		}
+	})
+}
+
+func TestDoLoginBrowserFails(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		client := newTestClient(t, keyhub, nil)
+		var output strings.Builder
+		WithBrowser(func(url string) error { return errors.New("no browser") })(client)
+		WithDeviceCodeOutput(&output)(client)
+
+		if _, err := client.DoLogin(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output.String(), keyhubtest.UserCode) {
+			t.Errorf("user code not shown when the browser could not be opened, got %q", output.String())
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package aws_keyhub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RevokeRefreshToken revokes the refresh token of the context at KeyHub and removes it from the secret store. Revoking
+// is best effort, the refresh token is removed as well when KeyHub has no revocation endpoint or cannot be reached.
+func (client *Client) RevokeRefreshToken(ctx context.Context) error {
+	refreshTokenFile, err := client.readRefreshToken()
+	switch {
+	case err != nil:
+		logrus.Warnln("Removing the KeyHub refresh token without revoking it, it could not be read.", err)
+	case refreshTokenFile == nil:
+		logrus.Debugln("No KeyHub refresh token to revoke.")
+	case isAccessTokenValid(*refreshTokenFile):
+		if err := client.revokeToken(ctx, refreshTokenFile.RefreshToken); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("KeyHub logout aborted: %w", ctx.Err())
+			}
+			logrus.Warnln("Failed to revoke the KeyHub refresh token, removing it anyway.", err)
+		}
+	}
+
+	secretStore, err := client.getSecretStore()
+	if err != nil {
+		return err
+	}
+	if err := secretStore.Delete(client.contextName); err != nil {
+		return err
+	}
+	// A refresh token file written before another secret store was configured, when it could not be moved.
+	if _, isFile := secretStore.(fileSecretStore); !isFile {
+		if err := (fileSecretStore{}).Delete(client.contextName); err != nil {
+			return err
+		}
+	}
+	logrus.Debugln("Removed the KeyHub refresh token.")
+	return nil
+}
+
+// revokeToken revokes the token at the KeyHub revocation endpoint, as described in RFC 7009. A KeyHub without
+// revocation endpoint is not an error.
+func (client *Client) revokeToken(ctx context.Context, token string) error {
+	data := url.Values{
+		"token":           {token},
+		"token_type_hint": {"refresh_token"},
+		"client_id":       {client.Config.Keyhub.ClientId},
+	}
+	resp, err := client.postForm(ctx, "/login/oauth2/revoke", data)
+	if err != nil {
+		return fmt.Errorf("failed to post form data to KeyHub revocation endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		logrus.Infoln("Revoked the KeyHub refresh token.")
+		return nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		logrus.Debugln("KeyHub does not support token revocation, the refresh token stays valid until it expires.")
+		return nil
+	case http.StatusBadRequest, http.StatusUnauthorized:
+		return handleErrorResponse(resp)
+	default:
+		return handleUnexpectedResponseCodeResponse(resp)
+	}
+}
+
+// RemoveProfile removes the session login wrote to the profile, from the credentials file and from the credential
+// cache, or its credential process from the AWS config file when the credentials are encrypted. The credentials in the
+// profile are kept when they were replaced outside of aws-keyhub. It reports whether login wrote the profile.
+func (client *Client) RemoveProfile(profile string) (bool, error) {
+	unlock, err := client.lockCredentialCache()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	cache, err := client.readCredentialCache()
+	if err != nil {
+		return false, err
+	}
+	found := false
+	var accessKeyIds, roleArns []string
+	for roleArn, cachedCredentials := range cache {
+		if cachedCredentials.Profile != profile {
+			continue
+		}
+		roleArns = append(roleArns, roleArn)
+		if cachedCredentials.Credentials.AccessKeyId != nil {
+			accessKeyIds = append(accessKeyIds, *cachedCredentials.Credentials.AccessKeyId)
+		}
+		delete(cache, roleArn)
+		found = true
+	}
+	if !found {
+		return false, nil
+	}
+
+	// Encrypted credentials are never written to the credentials file, the profile runs credential-process instead.
+	if client.Config.Aws.EncryptCredentials {
+		if err := RemoveCredentialProcessProfile(profile, roleArns); err != nil {
+			return false, err
+		}
+	} else {
+		accessKeyId, err := ReadCredentialFileAccessKeyId(profile)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case accessKeyId == "":
+		case slices.Contains(accessKeyIds, accessKeyId):
+			if err := RemoveCredentialFileProfile(profile); err != nil {
+				return false, err
+			}
+		default:
+			logrus.Warnf("Keeping the credentials of profile '%s', they were changed outside of aws-keyhub.", profile)
+		}
+	}
+	return true, client.writeCredentialCache(cache)
+}
+
+// ClearCredentialCache removes the cached sessions of the context. When keepProfiles is set, the sessions written to a
+// profile are kept, so RemoveProfile can still remove them from the credentials file.
+func (client *Client) ClearCredentialCache(keepProfiles bool) error {
+	unlock, err := client.lockCredentialCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if keepProfiles {
+		cache, err := client.readCredentialCache()
+		if err != nil {
+			return err
+		}
+		for roleArn, cachedCredentials := range cache {
+			if cachedCredentials.Profile == "" {
+				delete(cache, roleArn)
+			}
+		}
+		if len(cache) > 0 {
+			return client.writeCredentialCache(cache)
+		}
+	}
+
+	for _, pathForContext := range []func(string) (string, error){getAwsKeyHubCredentialCachePathForContext, getAwsKeyHubEncryptedCredentialCachePathForContext} {
+		path, err := pathForContext(client.contextName)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove aws-keyhub credential cache: %w", err)
+		}
+	}
+	secretStore, err := client.getSecretStore()
+	if err != nil {
+		return err
+	}
+	if keyringStore, isKeyring := secretStore.(keyringSecretStore); isKeyring {
+		if err := keyringStore.Delete(credentialCacheKeyAccount(client.contextName)); err != nil {
+			return err
+		}
+	}
+	logrus.Debugln("Removed the aws-keyhub credential cache.")
+	return nil
+}
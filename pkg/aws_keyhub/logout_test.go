@@ -0,0 +1,93 @@
+package aws_keyhub
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+)
+
+func TestRevokeRefreshToken(t *testing.T) {
+	for _, unsupported := range []bool{false, true} {
+		synctest.Test(t, func(t *testing.T) {
+			keyhub := keyhubtest.NewKeyHub(testRoles...)
+			keyhub.RevocationUnsupported = unsupported
+			client := newTestClient(t, keyhub, nil)
+			ctx := context.Background()
+
+			if _, err := client.DoLogin(ctx); err != nil {
+				t.Fatal(err)
+			}
+			refreshTokenFile, _ := client.readRefreshToken()
+			if err := client.RevokeRefreshToken(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if revocations := keyhub.Requests("revoke"); revocations != 1 {
+				t.Errorf("revoked %d times, want 1", revocations)
+			}
+			if valid := keyhub.IsRefreshTokenValid(refreshTokenFile.RefreshToken); valid != unsupported {
+				t.Errorf("refresh token valid %t after logout, revocation unsupported %t", valid, unsupported)
+			}
+			if stored, _ := client.readRefreshToken(); stored != nil {
+				t.Error("refresh token still stored after logout")
+			}
+		})
+	}
+}
+
+func TestRemoveProfile(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	expiration := time.Now().Add(time.Hour)
+	session := func(accessKeyId string) *types.Credentials {
+		return &types.Credentials{AccessKeyId: aws.String(accessKeyId), SecretAccessKey: aws.String("secret"), SessionToken: aws.String("token"), Expiration: &expiration}
+	}
+	for profile, accessKeyId := range map[string]string{"written": "ASIAWRITTEN", "changed": "ASIACHANGED"} {
+		if err := WriteCredentialFile(profile, session(accessKeyId)); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.StoreCachedCredentials(RolesAndPrincipals{Role: "arn:aws:iam::123456789012:role/" + profile}, profile, session(accessKeyId)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteCredentialFile("changed", session("AKIAOTHER")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCredentialFile("unrelated", session("AKIAUNRELATED")); err != nil {
+		t.Fatal(err)
+	}
+	credentialFilePath, _ := getCredentialFilePath()
+	credentialFile, _ := os.ReadFile(credentialFilePath)
+	credentialFile = []byte(strings.Replace(string(credentialFile), "[written]\n", "[written]\nregion = eu-west-1\n", 1))
+	if err := os.WriteFile(credentialFilePath, credentialFile, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, profile := range []string{"written", "changed", "unrelated"} {
+		removed, err := client.RemoveProfile(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != (profile != "unrelated") {
+			t.Errorf("profile %s removed %t", profile, removed)
+		}
+	}
+
+	for profile, expected := range map[string]string{"written": "", "changed": "AKIAOTHER", "unrelated": "AKIAUNRELATED"} {
+		if accessKeyId, _ := ReadCredentialFileAccessKeyId(profile); accessKeyId != expected {
+			t.Errorf("profile %s has access key ID %q, want %q", profile, accessKeyId, expected)
+		}
+	}
+	if credentialFile, _ := os.ReadFile(credentialFilePath); !strings.Contains(string(credentialFile), "[written]\nregion = eu-west-1\n") {
+		t.Errorf("other keys of the profile were removed:\n%s", credentialFile)
+	}
+	if tracked, _ := client.GetTrackedProfiles(); len(tracked) != 0 {
+		t.Errorf("profiles %v still cached", tracked)
+	}
+}
@@ -0,0 +1,120 @@
+package aws_keyhub
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultProfile is used for a single role when no profile template is configured.
+const DefaultProfile = "keyhub"
+
+// DefaultProfileTemplate is used for multiple roles when no profile template is configured.
+const DefaultProfileTemplate = "keyhub-{{.AccountId}}-{{.RoleName}}"
+
+var profileTemplateFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": strings.ReplaceAll,
+	"trim":    strings.TrimSpace,
+}
+
+// ProfileNameData is the data available in a profile name template.
+type ProfileNameData struct {
+	RoleArn      string
+	PrincipalArn string
+	AccountId    string
+	RoleName     string
+	Description  string
+}
+
+func NewProfileNameData(roleAndPrincipal RolesAndPrincipals) ProfileNameData {
+	return ProfileNameData{
+		RoleArn:      roleAndPrincipal.Role,
+		PrincipalArn: roleAndPrincipal.Principal,
+		AccountId:    accountIdFromArn(roleAndPrincipal.Role),
+		RoleName:     roleNameFromArn(roleAndPrincipal.Role),
+		Description:  roleAndPrincipal.Description,
+	}
+}
+
+// ResolveProfileName returns the profile that was used for the role before, or otherwise the profile based on the
+// configured profile template. The fallback template is used when no profile template is configured. For multiple
+// roles, a profile that was used before for more than one role is ignored, as the roles would overwrite each other.
+func (client *Client) ResolveProfileName(roleAndPrincipal RolesAndPrincipals, fallbackTemplate string, multipleRoles bool) (string, error) {
+	if profile, exists := client.Config.Aws.Profiles[roleAndPrincipal.Role]; exists && (!multipleRoles || !client.isSharedProfile(profile)) {
+		logrus.Debugf("Using profile %s that was used before for role %s", profile, roleAndPrincipal.Role)
+		return profile, nil
+	}
+	profileTemplate := client.Config.Aws.ProfileTemplate
+	if profileTemplate == "" {
+		profileTemplate = fallbackTemplate
+	}
+	return ProfileName(profileTemplate, roleAndPrincipal)
+}
+
+// isSharedProfile reports whether the profile was used before for more than one role.
+func (client *Client) isSharedProfile(profile string) bool {
+	roles := 0
+	for _, rememberedProfile := range client.Config.Aws.Profiles {
+		if rememberedProfile == profile {
+			roles++
+		}
+	}
+	return roles > 1
+}
+
+func ProfileName(profileTemplate string, roleAndPrincipal RolesAndPrincipals) (string, error) {
+	tmpl, err := parseProfileTemplate(profileTemplate)
+	if err != nil {
+		return "", err
+	}
+	var profileName bytes.Buffer
+	if err := tmpl.Execute(&profileName, NewProfileNameData(roleAndPrincipal)); err != nil {
+		return "", fmt.Errorf("failed to determine profile name with template '%s': %w", profileTemplate, err)
+	}
+	return sanitizeProfileName(profileName.String())
+}
+
+func parseProfileTemplate(profileTemplate string) (*template.Template, error) {
+	tmpl, err := template.New("profile").Funcs(profileTemplateFuncs).Option("missingkey=error").Parse(profileTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile name template '%s': %w", profileTemplate, err)
+	}
+	return tmpl, nil
+}
+
+// sanitizeProfileName replaces the characters that cannot be used in an AWS profile (ini section) name.
+func sanitizeProfileName(profileName string) (string, error) {
+	profileName = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '[', ']', '/', '\\', '#', ';':
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(profileName))
+	if profileName == "" {
+		return "", errors.New("profile name template resulted in an empty profile name")
+	}
+	return profileName, nil
+}
+
+// accountIdFromArn returns the account ID of an ARN like arn:aws:iam::123456789012:role/example-role
+func accountIdFromArn(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
+
+// roleNameFromArn returns the role name of an ARN like arn:aws:iam::123456789012:role/path/example-role
+func roleNameFromArn(arn string) string {
+	parts := strings.Split(arn, ":")
+	resource := parts[len(parts)-1]
+	return resource[strings.LastIndex(resource, "/")+1:]
+}
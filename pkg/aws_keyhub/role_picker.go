@@ -0,0 +1,116 @@
+package aws_keyhub
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// rolePickerPageSize is the number of roles the role picker shows at once.
+const rolePickerPageSize = 15
+
+const (
+	favoriteRoleMarker = "★"
+	recentRoleMarker   = "•"
+)
+
+// rolePicker holds the roles in the order of the role picker: the favorite roles, the recently selected roles and
+// then the other roles grouped by account.
+type rolePicker struct {
+	roles  []RolesAndPrincipals
+	labels []string
+	// searchTexts are what the filter is matched against per role.
+	searchTexts []string
+}
+
+// newRolePicker returns the role picker for the roles.
+func (client *Client) newRolePicker(roles []RolesAndPrincipals) *rolePicker {
+	favoriteRoles, recentRoles := client.Config.Aws.FavoriteRoles, client.Config.Aws.RecentRoles
+	section := func(role RolesAndPrincipals) (int, int) {
+		if index := slices.Index(favoriteRoles, role.Role); index >= 0 {
+			return 0, index
+		}
+		if index := slices.Index(recentRoles, role.Role); index >= 0 {
+			return 1, index
+		}
+		return 2, 0
+	}
+	roles = slices.Clone(roles)
+	slices.SortFunc(roles, func(a, b RolesAndPrincipals) int {
+		aSection, aIndex := section(a)
+		bSection, bIndex := section(b)
+		return cmp.Or(
+			cmp.Compare(aSection, bSection),
+			cmp.Compare(aIndex, bIndex),
+			cmp.Compare(accountIdFromArn(a.Role), accountIdFromArn(b.Role)),
+			cmp.Compare(a.Description, b.Description),
+			cmp.Compare(a.Role, b.Role),
+		)
+	})
+
+	picker := &rolePicker{roles: roles}
+	var accounts, roleNames []string
+	for _, role := range roles {
+		accounts = append(accounts, client.accountName(accountIdFromArn(role.Role)))
+		roleNames = append(roleNames, roleNameFromArn(role.Role))
+		picker.searchTexts = append(picker.searchTexts, client.roleSearchText(role))
+	}
+	accountWidth, roleNameWidth := maxLength(accounts), maxLength(roleNames)
+	for i, role := range roles {
+		marker := " "
+		switch section, _ := section(role); section {
+		case 0:
+			marker = favoriteRoleMarker
+		case 1:
+			marker = recentRoleMarker
+		}
+		label := fmt.Sprintf("%s %-*s  %-*s  %s", marker, accountWidth, accounts[i], roleNameWidth, roleNames[i], role.Description)
+		picker.labels = append(picker.labels, strings.TrimRight(label, " "))
+	}
+	return picker
+}
+
+// roleSearchText is what a filter is matched against: the account, role name, description and ARN of the role.
+func (client *Client) roleSearchText(role RolesAndPrincipals) string {
+	return strings.Join([]string{client.accountName(accountIdFromArn(role.Role)), roleNameFromArn(role.Role), role.Description, role.Role}, " ")
+}
+
+// filter is the survey filter of the role picker, it matches what the user types with fuzzyMatch.
+func (picker *rolePicker) filter(filter string, _ string, index int) bool {
+	return fuzzyMatch(filter, picker.searchTexts[index])
+}
+
+// accountName returns the account ID, together with its alias when one is configured.
+func (client *Client) accountName(accountId string) string {
+	if alias, exists := client.Config.Aws.AccountAliases[accountId]; exists && alias != "" {
+		return alias + " (" + accountId + ")"
+	}
+	return accountId
+}
+
+// fuzzyMatch reports whether every word of the pattern is in the text with its characters in order, but not
+// necessarily next to each other, ignoring case. For example "prod adm" matches "production - admin".
+func fuzzyMatch(pattern string, text string) bool {
+	text = strings.ToLower(text)
+	for _, word := range strings.Fields(strings.ToLower(pattern)) {
+		remaining := text
+		for _, r := range word {
+			index := strings.IndexRune(remaining, r)
+			if index < 0 {
+				return false
+			}
+			remaining = remaining[index+utf8.RuneLen(r):]
+		}
+	}
+	return true
+}
+
+func maxLength(values []string) int {
+	length := 0
+	for _, value := range values {
+		length = max(length, utf8.RuneCountInString(value))
+	}
+	return length
+}
@@ -0,0 +1,106 @@
+package aws_keyhub
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		text    string
+		match   bool
+	}{
+		{"", "production - admin", true},
+		{"admin", "production - admin", true},
+		{"prod adm", "production - admin", true},
+		{"PRDADM", "production - admin", true},
+		{"adm prod", "production - admin", true},
+		{"admprod", "production - admin", false},
+		{"acceptance", "production - admin", false},
+	} {
+		if match := fuzzyMatch(test.pattern, test.text); match != test.match {
+			t.Errorf("fuzzyMatch(%q, %q) = %t", test.pattern, test.text, match)
+		}
+	}
+}
+
+func TestRolePicker(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	roles := map[string]RolesAndPrincipals{}
+	for _, roleArn := range []string{
+		"arn:aws:iam::333333333333:role/Admin",
+		"arn:aws:iam::111111111111:role/ReadOnly",
+		"arn:aws:iam::111111111111:role/Admin",
+		"arn:aws:iam::222222222222:role/Admin",
+		"arn:aws:iam::222222222222:role/Developer",
+	} {
+		roles[roleArn] = RolesAndPrincipals{Role: roleArn, Description: "description of " + roleArn[len("arn:aws:iam::"):]}
+	}
+	client.Config.Aws.FavoriteRoles = []string{"arn:aws:iam::333333333333:role/Admin"}
+	client.Config.Aws.RecentRoles = []string{"arn:aws:iam::222222222222:role/Developer", "arn:aws:iam::333333333333:role/Admin"}
+	client.Config.Aws.AccountAliases = map[string]string{"111111111111": "production"}
+
+	picker := client.newRolePicker(SortedRolesAndPrincipals(roles))
+	var order []string
+	for _, role := range picker.roles {
+		order = append(order, role.Role)
+	}
+	expected := []string{
+		"arn:aws:iam::333333333333:role/Admin",
+		"arn:aws:iam::222222222222:role/Developer",
+		"arn:aws:iam::111111111111:role/Admin",
+		"arn:aws:iam::111111111111:role/ReadOnly",
+		"arn:aws:iam::222222222222:role/Admin",
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("roles in order %v, want %v", order, expected)
+	}
+	if !strings.HasPrefix(picker.labels[0], favoriteRoleMarker+" 333333333333") || !strings.HasPrefix(picker.labels[1], recentRoleMarker+" ") {
+		t.Errorf("favorite and recent role not marked: %q", picker.labels)
+	}
+	if label := picker.labels[2]; label != "  production (111111111111)  Admin      description of 111111111111:role/Admin" {
+		t.Errorf("label %q", label)
+	}
+	if !picker.filter("prod read", picker.labels[3], 3) || picker.filter("prod dev", picker.labels[2], 2) {
+		t.Error("the filter of the picker does not match the account alias and role name")
+	}
+}
+
+func TestSelectRoleAndPrincipalFilter(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	roles := map[string]RolesAndPrincipals{}
+	for _, role := range testRoles {
+		roles[role.RoleArn] = RolesAndPrincipals{Role: role.RoleArn, Principal: role.PrincipalArn, Description: role.Description}
+	}
+
+	selected, err := client.SelectRoleAndPrincipal(RoleSelector{Filter: "read only"}, roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selected.Role != testRoles[1].RoleArn {
+		t.Errorf("selected role %s, want the only role matching the filter", selected.Role)
+	}
+	config, err := LoadAwsKeyHubConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Aws.RecentRoles, []string{testRoles[1].RoleArn}) {
+		t.Errorf("recent roles %v", config.Aws.RecentRoles)
+	}
+}
+
+func TestStoreRecentRoles(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	for _, roleArns := range [][]string{{"a", "b"}, {"c", "d", "e"}, {"f", "b"}} {
+		if err := client.StoreRecentRoles(roleArns...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := []string{"f", "b", "c", "d", "e"}; !reflect.DeepEqual(client.Config.Aws.RecentRoles, expected) {
+		t.Errorf("recent roles %v, want %v", client.Config.Aws.RecentRoles, expected)
+	}
+}
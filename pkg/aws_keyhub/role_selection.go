@@ -2,58 +2,251 @@ package aws_keyhub
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/sirupsen/logrus"
-	"strings"
 )
 
-func SelectRoleAndPrincipal(roleArn string, rolesAndPrincipals map[string]RolesAndPrincipals) RolesAndPrincipals {
-	if len(roleArn) > 0 {
-		rolesAndPrincipal, err := findRoleAndPrincipalByOption(roleArn, rolesAndPrincipals)
-		if err != nil {
-			return promptForRole(rolesAndPrincipals)
+// RoleSelector selects roles from the SAML assertion by role ARN, account, role name or pattern. Empty fields match all
+// roles.
+type RoleSelector struct {
+	// RoleArns are the exact ARNs of the roles, it is an error when one of them is not available.
+	RoleArns []string
+	// Account is the account ID or the account alias (see KeyhubAwsConfig.AccountAliases) of the roles.
+	Account string
+	// RoleName is the name of the roles without path, ignoring case.
+	RoleName string
+	// Role is a glob pattern, with * and ?, matched against the role ARN, role name, description and account alias of
+	// the roles, ignoring case.
+	Role string
+	// Filter narrows the roles in the role picker, see fuzzyMatch. The only role that matches is selected without
+	// prompting.
+	Filter string
+}
+
+// IsEmpty reports whether the selector matches all roles.
+func (selector RoleSelector) IsEmpty() bool {
+	return !selector.selects() && selector.Filter == ""
+}
+
+// selects reports whether the selector picks the roles itself, rather than only narrowing the role picker.
+func (selector RoleSelector) selects() bool {
+	return len(selector.RoleArns) > 0 || selector.Account != "" || selector.RoleName != "" || selector.Role != ""
+}
+
+func (selector RoleSelector) String() string {
+	var criteria []string
+	for _, roleArn := range selector.RoleArns {
+		criteria = append(criteria, fmt.Sprintf("role ARN '%s'", roleArn))
+	}
+	for _, criterion := range [][2]string{{"account", selector.Account}, {"role name", selector.RoleName}, {"role", selector.Role}, {"filter", selector.Filter}} {
+		if criterion[1] != "" {
+			criteria = append(criteria, fmt.Sprintf("%s '%s'", criterion[0], criterion[1]))
 		}
-		logrus.Infoln("Selected role", rolesAndPrincipal.Role, "based on -r parameter.")
-		return rolesAndPrincipal
 	}
+	if len(criteria) == 0 {
+		return "all roles"
+	}
+	return strings.Join(criteria, ", ")
+}
 
-	return promptForRole(rolesAndPrincipals)
+// MatchRoles returns the roles that match the selector, sorted by role ARN. A RoleNotFoundError is returned when one of
+// the role ARNs is not available, and a NoMatchingRoleError when no role matches.
+func (client *Client) MatchRoles(selector RoleSelector, rolesAndPrincipals map[string]RolesAndPrincipals) ([]RolesAndPrincipals, error) {
+	for _, roleArn := range selector.RoleArns {
+		if _, err := FindRoleAndPrincipalByRoleArn(roleArn, rolesAndPrincipals); err != nil {
+			return nil, err
+		}
+	}
+	rolePattern, err := globToRegexp(selector.Role)
+	if err != nil {
+		return nil, err
+	}
+	matches := slices.DeleteFunc(SortedRolesAndPrincipals(rolesAndPrincipals), func(role RolesAndPrincipals) bool {
+		accountId := accountIdFromArn(role.Role)
+		alias := client.Config.Aws.AccountAliases[accountId]
+		roleName := roleNameFromArn(role.Role)
+		switch {
+		case len(selector.RoleArns) > 0 && !slices.Contains(selector.RoleArns, role.Role):
+		case selector.Account != "" && selector.Account != accountId && !strings.EqualFold(selector.Account, alias):
+		case selector.RoleName != "" && !strings.EqualFold(selector.RoleName, roleName):
+		case rolePattern != nil && !slices.ContainsFunc([]string{role.Role, roleName, role.Description, alias}, rolePattern.MatchString):
+		case !fuzzyMatch(selector.Filter, client.roleSearchText(role)):
+		default:
+			return false
+		}
+		return true
+	})
+	if len(matches) == 0 && selector.IsEmpty() {
+		return nil, errors.New("no roles available in the SAML assertion received from KeyHub")
+	} else if len(matches) == 0 {
+		return nil, &NoMatchingRoleError{Selector: selector}
+	}
+	return matches, nil
 }
 
-func promptForRole(rolesAndPrincipals map[string]RolesAndPrincipals) RolesAndPrincipals {
-	var options []string
-	for value := range rolesAndPrincipals {
-		roleAndPrincipal := rolesAndPrincipals[value]
-		options = append(options, roleAndPrincipal.Role+" / "+roleAndPrincipal.Description)
+// globToRegexp returns a case-insensitive regular expression for the glob pattern, or nil for an empty pattern.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	var expression strings.Builder
+	expression.WriteString("(?i)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expression.WriteString(".*")
+		case '?':
+			expression.WriteString(".")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(r)))
+		}
 	}
+	expression.WriteString("$")
+	return regexp.Compile(expression.String())
+}
 
-	var selectedOption string
+// SelectRoleAndPrincipal returns the role matching the selector, or otherwise prompts for a role with the role picker,
+// showing the roles that match. When the client does not prompt, an AmbiguousRoleError is returned instead, or
+// ErrRoleRequired when the selector is empty. A role selected while prompting is allowed is remembered as recently
+// used.
+func (client *Client) SelectRoleAndPrincipal(selector RoleSelector, rolesAndPrincipals map[string]RolesAndPrincipals) (RolesAndPrincipals, error) {
+	candidates, err := client.MatchRoles(selector, rolesAndPrincipals)
+	if err != nil {
+		return RolesAndPrincipals{}, err
+	}
+	var selected RolesAndPrincipals
+	switch {
+	case len(candidates) == 1 && !selector.IsEmpty():
+		selected = candidates[0]
+		logrus.Infof("Selected role %s, the only role matching %s.", selected.Role, selector)
+	case client.noPrompts && selector.IsEmpty():
+		return RolesAndPrincipals{}, ErrRoleRequired
+	case client.noPrompts:
+		return RolesAndPrincipals{}, &AmbiguousRoleError{Selector: selector, RoleArns: roleArns(candidates)}
+	default:
+		if selector.selects() {
+			logrus.Infof("%d roles match %s.", len(candidates), selector)
+		}
+		if selected, err = client.promptForRole(candidates); err != nil {
+			return RolesAndPrincipals{}, err
+		}
+	}
+	client.storeRecentRoles(selected)
+	return selected, nil
+}
+
+func (client *Client) promptForRole(roles []RolesAndPrincipals) (RolesAndPrincipals, error) {
+	picker := client.newRolePicker(roles)
+	var selectedIndex int
 	err := survey.AskOne(&survey.Select{
-		Message: "Choose a role",
-		Options: options,
-	}, &selectedOption)
+		Message:  "Choose a role",
+		Options:  picker.labels,
+		PageSize: rolePickerPageSize,
+	}, &selectedIndex, survey.WithFilter(picker.filter), promptStdio())
+	if err != nil {
+		return RolesAndPrincipals{}, fmt.Errorf("failed to prompt user for role: %w", err)
+	}
+	logrus.Debugln("User selected role:", picker.roles[selectedIndex].Role)
+	return picker.roles[selectedIndex], nil
+}
 
-	logrus.Debugln("User selected option:", selectedOption)
+// FindRoleAndPrincipalByRoleArn returns the role with exactly the given ARN.
+func FindRoleAndPrincipalByRoleArn(roleArn string, rolesAndPrincipals map[string]RolesAndPrincipals) (RolesAndPrincipals, error) {
+	for _, roleAndPrincipal := range rolesAndPrincipals {
+		if roleAndPrincipal.Role == roleArn {
+			return roleAndPrincipal, nil
+		}
+	}
+	return RolesAndPrincipals{}, &RoleNotFoundError{RoleArn: roleArn}
+}
+
+// SelectRolesAndPrincipals returns all roles matching the selector, or otherwise prompts for one or more roles with
+// the role picker. A filter on its own only narrows the roles in the picker. When the client does not prompt, an
+// AmbiguousRoleError or ErrRoleRequired is returned instead. Roles selected while prompting is allowed are remembered as
+// recently used.
+func (client *Client) SelectRolesAndPrincipals(selector RoleSelector, rolesAndPrincipals map[string]RolesAndPrincipals) ([]RolesAndPrincipals, error) {
+	candidates, err := client.MatchRoles(selector, rolesAndPrincipals)
 	if err != nil {
-		logrus.Fatal("Failed to prompt user for role.", err)
+		return nil, err
 	}
+	selected := candidates
+	switch {
+	case selector.selects() || (len(candidates) == 1 && !selector.IsEmpty()):
+		logrus.Infof("Selected %d roles matching %s.", len(candidates), selector)
+	case client.noPrompts && selector.IsEmpty():
+		return nil, ErrRoleRequired
+	case client.noPrompts:
+		return nil, &AmbiguousRoleError{Selector: selector, RoleArns: roleArns(candidates)}
+	default:
+		if selected, err = client.promptForRoles(candidates); err != nil {
+			return nil, err
+		}
+	}
+	client.storeRecentRoles(selected...)
+	return selected, nil
+}
 
-	rolesAndPrincipal, err := findRoleAndPrincipalByOption(selectedOption, rolesAndPrincipals)
+// SortedRolesAndPrincipals returns the roles sorted by role ARN, leaving out group metadata without a matching role.
+func SortedRolesAndPrincipals(rolesAndPrincipals map[string]RolesAndPrincipals) []RolesAndPrincipals {
+	var sorted []RolesAndPrincipals
+	for _, roleAndPrincipal := range rolesAndPrincipals {
+		if roleAndPrincipal.Role != "" {
+			sorted = append(sorted, roleAndPrincipal)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Role < sorted[j].Role
+	})
+	return sorted
+}
+
+func (client *Client) promptForRoles(roles []RolesAndPrincipals) ([]RolesAndPrincipals, error) {
+	picker := client.newRolePicker(roles)
+	var selectedIndexes []int
+	err := survey.AskOne(&survey.MultiSelect{
+		Message:  "Choose one or more roles",
+		Options:  picker.labels,
+		PageSize: rolePickerPageSize,
+	}, &selectedIndexes, survey.WithValidator(survey.Required), survey.WithFilter(picker.filter), promptStdio())
 	if err != nil {
-		logrus.Fatal("Failed to find role and principal by role that the user selected in the prompt.", err)
+		return nil, fmt.Errorf("failed to prompt user for roles: %w", err)
 	}
 
-	return rolesAndPrincipal
+	var selected []RolesAndPrincipals
+	for _, selectedIndex := range selectedIndexes {
+		selected = append(selected, picker.roles[selectedIndex])
+	}
+	logrus.Debugln("User selected roles:", selected)
+	return selected, nil
 }
 
-func findRoleAndPrincipalByOption(selectedOption string, rolesAndPrincipals map[string]RolesAndPrincipals) (RolesAndPrincipals, error) {
+// storeRecentRoles remembers the roles as recently used, failing to do so does not fail the login. Roles selected by
+// a client that does not prompt, like credential-process, are not remembered.
+func (client *Client) storeRecentRoles(roles ...RolesAndPrincipals) {
+	if client.noPrompts {
+		return
+	}
+	if err := client.StoreRecentRoles(roleArns(roles)...); err != nil {
+		logrus.Warnln("Failed to remember the recently used roles.", err)
+	}
+}
 
-	for value := range rolesAndPrincipals {
-		roleAndPrincipal := rolesAndPrincipals[value]
-		if strings.HasPrefix(selectedOption, roleAndPrincipal.Role) {
-			logrus.Debug("Found role and principal by option:", selectedOption, roleAndPrincipal)
-			return roleAndPrincipal, nil
-		}
+func roleArns(roles []RolesAndPrincipals) []string {
+	var arns []string
+	for _, role := range roles {
+		arns = append(arns, role.Role)
 	}
-	return RolesAndPrincipals{}, errors.New("unable to find matching Role and Principal based on user selected option")
+	return arns
+}
+
+// promptStdio renders the role prompts on stderr, so the prompt is visible when stdout is captured (e.g. with eval).
+func promptStdio() survey.AskOpt {
+	return survey.WithStdio(os.Stdin, os.Stderr, os.Stderr)
 }
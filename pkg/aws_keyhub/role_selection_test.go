@@ -0,0 +1,80 @@
+package aws_keyhub
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+)
+
+func TestMatchRoles(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	client.Config.Aws.AccountAliases = map[string]string{"111111111111": "production"}
+	roles := map[string]RolesAndPrincipals{}
+	for roleArn, description := range map[string]string{
+		"arn:aws:iam::111111111111:role/Admin":          "production - admin",
+		"arn:aws:iam::111111111111:role/ops/ReadOnly":   "production - read only",
+		"arn:aws:iam::222222222222:role/Admin":          "acceptance - admin",
+		"arn:aws:iam::222222222222:role/ReadOnlyAccess": "acceptance - read only",
+	} {
+		roles[roleArn] = RolesAndPrincipals{Role: roleArn, Description: description}
+	}
+
+	for _, test := range []struct {
+		selector RoleSelector
+		expected []string
+	}{
+		{RoleSelector{}, []string{"arn:aws:iam::111111111111:role/Admin", "arn:aws:iam::111111111111:role/ops/ReadOnly", "arn:aws:iam::222222222222:role/Admin", "arn:aws:iam::222222222222:role/ReadOnlyAccess"}},
+		{RoleSelector{Account: "222222222222"}, []string{"arn:aws:iam::222222222222:role/Admin", "arn:aws:iam::222222222222:role/ReadOnlyAccess"}},
+		{RoleSelector{Account: "Production", RoleName: "readonly"}, []string{"arn:aws:iam::111111111111:role/ops/ReadOnly"}},
+		{RoleSelector{RoleName: "admin"}, []string{"arn:aws:iam::111111111111:role/Admin", "arn:aws:iam::222222222222:role/Admin"}},
+		{RoleSelector{Role: "ReadOnly*"}, []string{"arn:aws:iam::111111111111:role/ops/ReadOnly", "arn:aws:iam::222222222222:role/ReadOnlyAccess"}},
+		{RoleSelector{Role: "acceptance - ad?in"}, []string{"arn:aws:iam::222222222222:role/Admin"}},
+		{RoleSelector{Role: "production", RoleName: "Admin"}, []string{"arn:aws:iam::111111111111:role/Admin"}},
+		{RoleSelector{Role: "*:role/ops/*"}, []string{"arn:aws:iam::111111111111:role/ops/ReadOnly"}},
+		{RoleSelector{RoleArns: []string{"arn:aws:iam::222222222222:role/Admin"}}, []string{"arn:aws:iam::222222222222:role/Admin"}},
+		{RoleSelector{Filter: "acc only"}, []string{"arn:aws:iam::222222222222:role/ReadOnlyAccess"}},
+	} {
+		matches, err := client.MatchRoles(test.selector, roles)
+		if err != nil {
+			t.Errorf("%s: %v", test.selector, err)
+			continue
+		}
+		if arns := roleArns(matches); !reflect.DeepEqual(arns, test.expected) {
+			t.Errorf("%s matched %v, want %v", test.selector, arns, test.expected)
+		}
+	}
+
+	var noMatchingRoleError *NoMatchingRoleError
+	if _, err := client.MatchRoles(RoleSelector{Account: "333333333333"}, roles); !errors.As(err, &noMatchingRoleError) {
+		t.Errorf("got %v, want a NoMatchingRoleError", err)
+	}
+	var roleNotFoundError *RoleNotFoundError
+	if _, err := client.MatchRoles(RoleSelector{RoleArns: []string{"arn:aws:iam::111111111111:role/Unknown"}}, roles); !errors.As(err, &roleNotFoundError) {
+		t.Errorf("got %v, want a RoleNotFoundError", err)
+	}
+}
+
+func TestSelectRoleAndPrincipalWithoutPrompts(t *testing.T) {
+	keyhubtest.SetupHome(t)
+	client := NewClient(KeyhubConfigFile{}, WithoutPrompts())
+	roles := map[string]RolesAndPrincipals{}
+	for _, role := range testRoles {
+		roles[role.RoleArn] = RolesAndPrincipals{Role: role.RoleArn, Principal: role.PrincipalArn, Description: role.Description}
+	}
+
+	if selected, err := client.SelectRoleAndPrincipal(RoleSelector{RoleName: "admin"}, roles); err != nil || selected.Role != testRoles[0].RoleArn {
+		t.Errorf("selected %v, %v, want %s", selected, err, testRoles[0].RoleArn)
+	}
+	var ambiguousRoleError *AmbiguousRoleError
+	if _, err := client.SelectRoleAndPrincipal(RoleSelector{Role: "*"}, roles); !errors.As(err, &ambiguousRoleError) || len(ambiguousRoleError.RoleArns) != 2 {
+		t.Errorf("got %v, want an AmbiguousRoleError for both roles", err)
+	}
+	if _, err := client.SelectRoleAndPrincipal(RoleSelector{}, roles); !errors.Is(err, ErrRoleRequired) {
+		t.Errorf("got %v, want ErrRoleRequired", err)
+	}
+	if selected, err := client.SelectRolesAndPrincipals(RoleSelector{Role: "*"}, roles); err != nil || len(selected) != 2 {
+		t.Errorf("selected %v, %v, want both roles", selected, err)
+	}
+}
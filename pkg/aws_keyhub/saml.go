@@ -4,23 +4,26 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"strings"
 )
 
-func DecodeSAMLResponse(samlResponse string) []byte {
+func DecodeSAMLResponse(samlResponse string) ([]byte, error) {
 	decoded, err := base64.URLEncoding.DecodeString(samlResponse)
 	if err != nil {
-		logrus.Fatal("Failed to decode SAML Response from KeyHub.", err)
+		return nil, fmt.Errorf("failed to decode SAML Response from KeyHub: %w", err)
 	}
 
 	logrus.Debugln("Decoded SAML Response", string(decoded))
-	return decoded
+	return decoded, nil
 }
 
-func RolesAndPrincipalsFromSamlResponse(samlResponseDecoded []byte) map[string]RolesAndPrincipals {
+func RolesAndPrincipalsFromSamlResponse(samlResponseDecoded []byte) (map[string]RolesAndPrincipals, error) {
 	var response Response
-	xml.Unmarshal(samlResponseDecoded, &response)
+	if err := xml.Unmarshal(samlResponseDecoded, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal SAML Response from KeyHub: %w", err)
+	}
 	logrus.Debugln("Unmarshalled SAML Assertion:", response)
 
 	var rolesAndPrincipals = make(map[string]RolesAndPrincipals)
@@ -62,7 +65,7 @@ func RolesAndPrincipalsFromSamlResponse(samlResponseDecoded []byte) map[string]R
 		}
 	}
 
-	return rolesAndPrincipals
+	return rolesAndPrincipals, nil
 }
 
 func splitRoleAndPrincipal(roleAndPrincipal string) (role string, principal string) {
@@ -0,0 +1,81 @@
+package aws_keyhub
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+)
+
+func TestRolesAndPrincipalsFromSamlResponse(t *testing.T) {
+	samlResponse := keyhubtest.SamlResponse([]keyhubtest.Role{
+		{RoleArn: "arn:aws:iam::123456789012:role/Admin", PrincipalArn: "arn:aws:iam::123456789012:saml-provider/keyhub", Description: "production - admin"},
+		{RoleArn: "arn:aws:iam::210987654321:role/ReadOnly", PrincipalArn: "arn:aws:iam::210987654321:saml-provider/keyhub"},
+	})
+
+	decoded, err := DecodeSAMLResponse(samlResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rolesAndPrincipals, err := RolesAndPrincipalsFromSamlResponse(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]RolesAndPrincipals{
+		"arn:aws:iam::123456789012:role/Admin,arn:aws:iam::123456789012:saml-provider/keyhub": {
+			Role:        "arn:aws:iam::123456789012:role/Admin",
+			Principal:   "arn:aws:iam::123456789012:saml-provider/keyhub",
+			Description: "production - admin",
+		},
+		"arn:aws:iam::210987654321:role/ReadOnly,arn:aws:iam::210987654321:saml-provider/keyhub": {
+			Role:      "arn:aws:iam::210987654321:role/ReadOnly",
+			Principal: "arn:aws:iam::210987654321:saml-provider/keyhub",
+		},
+	}
+	if !reflect.DeepEqual(rolesAndPrincipals, expected) {
+		t.Errorf("got %v, want %v", rolesAndPrincipals, expected)
+	}
+}
+
+func TestRolesAndPrincipalsFromSamlResponseWithGroupsBeforeRoles(t *testing.T) {
+	samlResponse := `<Response><Assertion><AttributeStatement>
+<Attribute Name="https://github.com/topicuskeyhub/aws-keyhub/groups"><AttributeValue>{"description": "acceptance - developer", "arn": "arn:aws:iam::123456789012:saml-provider/keyhub,arn:aws:iam::123456789012:role/Developer"}</AttributeValue></Attribute>
+<Attribute Name="https://aws.amazon.com/SAML/Attributes/Role"><AttributeValue>arn:aws:iam::123456789012:saml-provider/keyhub,arn:aws:iam::123456789012:role/Developer</AttributeValue></Attribute>
+</AttributeStatement></Assertion></Response>`
+
+	rolesAndPrincipals, err := RolesAndPrincipalsFromSamlResponse([]byte(samlResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := RolesAndPrincipals{
+		Role:        "arn:aws:iam::123456789012:role/Developer",
+		Principal:   "arn:aws:iam::123456789012:saml-provider/keyhub",
+		Description: "acceptance - developer",
+	}
+	if len(rolesAndPrincipals) != 1 {
+		t.Fatalf("got %d roles, want 1: %v", len(rolesAndPrincipals), rolesAndPrincipals)
+	}
+	for _, roleAndPrincipal := range rolesAndPrincipals {
+		if roleAndPrincipal != expected {
+			t.Errorf("got %v, want %v", roleAndPrincipal, expected)
+		}
+	}
+}
+
+func TestRolesAndPrincipalsFromSamlResponseInvalidXml(t *testing.T) {
+	if _, err := RolesAndPrincipalsFromSamlResponse([]byte("<Response><Assertion>")); err == nil {
+		t.Error("expected an error for a truncated SAML Response")
+	}
+}
+
+func TestDecodeSAMLResponseInvalidBase64(t *testing.T) {
+	_, err := DecodeSAMLResponse("not base64!")
+	var corruptInputError base64.CorruptInputError
+	if !errors.As(err, &corruptInputError) {
+		t.Errorf("got %v, want a base64.CorruptInputError", err)
+	}
+}
@@ -0,0 +1,347 @@
+package aws_keyhub
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// SecretStoreFile stores the refresh token in plain text in ~/.aws-keyhub, readable for the user only.
+	SecretStoreFile = "file"
+	// SecretStoreKeyring stores the refresh token in the Secret Service (D-Bus) on Linux, the Keychain on macOS or the
+	// Credential Manager on Windows.
+	SecretStoreKeyring = "keyring"
+	// SecretStoreEncryptedFile stores the refresh token in ~/.aws-keyhub encrypted with a passphrase using age, for
+	// machines without a keyring.
+	SecretStoreEncryptedFile = "encrypted-file"
+)
+
+var SecretStores = []string{SecretStoreFile, SecretStoreKeyring, SecretStoreEncryptedFile}
+
+// PassphraseEnvironmentVariable holds the passphrase of the encrypted-file secret store. The user is prompted for the
+// passphrase when it is not set.
+const PassphraseEnvironmentVariable = "AWS_KEYHUB_PASSPHRASE"
+
+// keyringService is the service name of the refresh tokens in the keyring, the account is the aws-keyhub context.
+const keyringService = "aws-keyhub"
+
+// SecretStore stores the KeyHub refresh token per aws-keyhub context.
+type SecretStore interface {
+	// Load returns the secret of the context, or nil when there is none.
+	Load(context string) ([]byte, error)
+	Store(context string, secret []byte) error
+	// Delete removes the secret of the context, it is not an error when there is none.
+	Delete(context string) error
+}
+
+// NewSecretStore returns the secret store with the name, one of SecretStores. An empty name is SecretStoreFile.
+func NewSecretStore(name string) (SecretStore, error) {
+	switch name {
+	case "", SecretStoreFile:
+		return fileSecretStore{}, nil
+	case SecretStoreKeyring:
+		return keyringSecretStore{}, nil
+	case SecretStoreEncryptedFile:
+		return &encryptedFileSecretStore{passphrase: promptPassphrase}, nil
+	}
+	return nil, fmt.Errorf("unsupported secret store %q, use one of %s", name, strings.Join(SecretStores, ", "))
+}
+
+// credentialEncrypter is implemented by the secret stores that can encrypt the credential cache of a context.
+// errCorruptCiphertext is returned by decrypt when the encrypted data is damaged, rather than encrypted with another key
+// or passphrase.
+var errCorruptCiphertext = errors.New("encrypted data is corrupt")
+
+type credentialEncrypter interface {
+	encrypt(context string, plaintext []byte) ([]byte, error)
+	decrypt(context string, ciphertext []byte) ([]byte, error)
+}
+
+// fileSecretStore is SecretStoreFile.
+type fileSecretStore struct{}
+
+func (fileSecretStore) Load(context string) ([]byte, error) {
+	path, err := getAwsKeyHubRefreshTokenPathForContext(context)
+	if err != nil {
+		return nil, err
+	}
+	return readSecretFile(path)
+}
+
+func (fileSecretStore) Store(context string, secret []byte) error {
+	path, err := getAwsKeyHubRefreshTokenPathForContext(context)
+	if err != nil {
+		return err
+	}
+	return writeSecretFile(path, secret)
+}
+
+func (fileSecretStore) Delete(context string) error {
+	path, err := getAwsKeyHubRefreshTokenPathForContext(context)
+	if err != nil {
+		return err
+	}
+	return removeSecretFile(path)
+}
+
+// keyringSecretStore is SecretStoreKeyring.
+type keyringSecretStore struct{}
+
+func (keyringSecretStore) Load(context string) ([]byte, error) {
+	secret, err := keyring.Get(keyringService, context)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read secret from the keyring: %w", err)
+	}
+	return []byte(secret), nil
+}
+
+func (keyringSecretStore) Store(context string, secret []byte) error {
+	if err := keyring.Set(keyringService, context, string(secret)); err != nil {
+		return fmt.Errorf("failed to store secret in the keyring: %w", err)
+	}
+	return nil
+}
+
+func (keyringSecretStore) Delete(context string) error {
+	if err := keyring.Delete(keyringService, context); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("failed to remove secret from the keyring: %w", err)
+	}
+	return nil
+}
+
+// encrypt seals the plaintext with NaCl secretbox, the random key is kept in the keyring next to the refresh token.
+func (store keyringSecretStore) encrypt(context string, plaintext []byte) ([]byte, error) {
+	key, err := store.credentialCacheKey(context)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	rand.Read(nonce[:]) // Never returns an error, it crashes the program instead.
+	return secretbox.Seal(nonce[:], plaintext, &nonce, key), nil
+}
+
+func (store keyringSecretStore) decrypt(context string, ciphertext []byte) ([]byte, error) {
+	key, err := store.credentialCacheKey(context)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if len(ciphertext) < len(nonce) {
+		return nil, fmt.Errorf("%w, it is too short", errCorruptCiphertext)
+	}
+	copy(nonce[:], ciphertext)
+	plaintext, ok := secretbox.Open(nil, ciphertext[len(nonce):], &nonce, key)
+	if !ok {
+		return nil, errors.New("failed to decrypt, the key in the keyring does not match")
+	}
+	return plaintext, nil
+}
+
+// credentialCacheKeyAccount is the keyring account of the key of the credential cache of the context.
+func credentialCacheKeyAccount(context string) string {
+	return context + "/credential-cache-key"
+}
+
+// credentialCacheKey returns the key of the credential cache of the context, a new key is stored in the keyring when
+// there is none.
+func (store keyringSecretStore) credentialCacheKey(context string) (*[32]byte, error) {
+	account := credentialCacheKeyAccount(context)
+	var key [32]byte
+	stored, err := store.Load(account)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) == len(key) {
+		copy(key[:], stored)
+		return &key, nil
+	}
+	rand.Read(key[:]) // Never returns an error, it crashes the program instead.
+	if err := store.Store(account, key[:]); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// encryptedFileSecretStore is SecretStoreEncryptedFile. The passphrase is asked once per process.
+type encryptedFileSecretStore struct {
+	passphrase func() (string, error)
+
+	mu               sync.Mutex
+	cachedPassphrase string
+}
+
+func (store *encryptedFileSecretStore) Load(context string) ([]byte, error) {
+	path, err := getAwsKeyHubEncryptedRefreshTokenPathForContext(context)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := readSecretFile(path)
+	if err != nil || encrypted == nil {
+		return nil, err
+	}
+	secret, err := store.decrypt(context, encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+	return secret, nil
+}
+
+func (store *encryptedFileSecretStore) Store(context string, secret []byte) error {
+	path, err := getAwsKeyHubEncryptedRefreshTokenPathForContext(context)
+	if err != nil {
+		return err
+	}
+	encrypted, err := store.encrypt(context, secret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt refresh token: %w", err)
+	}
+	return writeSecretFile(path, encrypted)
+}
+
+func (store *encryptedFileSecretStore) Delete(context string) error {
+	path, err := getAwsKeyHubEncryptedRefreshTokenPathForContext(context)
+	if err != nil {
+		return err
+	}
+	return removeSecretFile(path)
+}
+
+// encrypt encrypts the plaintext with age, using a key derived from the passphrase.
+func (store *encryptedFileSecretStore) encrypt(context string, plaintext []byte) ([]byte, error) {
+	passphrase, err := store.getPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	var encrypted bytes.Buffer
+	writer, err := age.Encrypt(&encrypted, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return encrypted.Bytes(), nil
+}
+
+func (store *encryptedFileSecretStore) decrypt(context string, ciphertext []byte) ([]byte, error) {
+	passphrase, err := store.getPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := age.Decrypt(bytes.NewReader(ciphertext), identity)
+	var noIdentityMatch *age.NoIdentityMatchError
+	if errors.As(err, &noIdentityMatch) {
+		return nil, fmt.Errorf("is the passphrase correct? %w", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", errCorruptCiphertext, err)
+	}
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errCorruptCiphertext, err)
+	}
+	return plaintext, nil
+}
+
+func (store *encryptedFileSecretStore) getPassphrase() (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.cachedPassphrase == "" {
+		passphrase, err := store.passphrase()
+		if err != nil {
+			return "", err
+		}
+		if passphrase == "" {
+			return "", errors.New("the passphrase of the encrypted refresh token is empty")
+		}
+		store.cachedPassphrase = passphrase
+	}
+	return store.cachedPassphrase, nil
+}
+
+// withoutPrompt returns a copy of the store that takes the passphrase from the environment, unless it was asked for
+// already.
+func (store *encryptedFileSecretStore) withoutPrompt() *encryptedFileSecretStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return &encryptedFileSecretStore{passphrase: environmentPassphrase, cachedPassphrase: store.cachedPassphrase}
+}
+
+// promptPassphrase returns the passphrase from the environment, or otherwise asks the user for it.
+func promptPassphrase() (string, error) {
+	if passphrase := os.Getenv(PassphraseEnvironmentVariable); passphrase != "" {
+		return passphrase, nil
+	}
+	var passphrase string
+	err := survey.AskOne(&survey.Password{Message: "Passphrase of the encrypted KeyHub refresh token:"}, &passphrase, promptStdio())
+	if err != nil {
+		return "", fmt.Errorf("failed to prompt for the passphrase of the encrypted refresh token, set %s instead: %w", PassphraseEnvironmentVariable, err)
+	}
+	return passphrase, nil
+}
+
+// environmentPassphrase returns the passphrase from the environment, for when the user cannot be asked for it.
+func environmentPassphrase() (string, error) {
+	if passphrase := os.Getenv(PassphraseEnvironmentVariable); passphrase != "" {
+		return passphrase, nil
+	}
+	return "", fmt.Errorf("set %s to the passphrase of the encrypted refresh token, aws-keyhub cannot prompt for it", PassphraseEnvironmentVariable)
+}
+
+func getAwsKeyHubEncryptedRefreshTokenPathForContext(context string) (string, error) {
+	contextDirectory, err := getAwsKeyHubContextDirectoryForContext(context)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(contextDirectory, "refresh-token.json.age"), nil
+}
+
+func readSecretFile(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading %s file: %w", path, err)
+	}
+	return secret, nil
+}
+
+func writeSecretFile(path string, secret []byte) error {
+	if err := os.WriteFile(path, secret, 0600); err != nil {
+		return fmt.Errorf("error writing to %s file: %w", path, err)
+	}
+	logrus.Debugln("Wrote refresh token to file at", path)
+	return nil
+}
+
+func removeSecretFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing %s file: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package aws_keyhub
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+	"github.com/zalando/go-keyring"
+)
+
+func TestKeyringSecretStore(t *testing.T) {
+	keyring.MockInit()
+	synctest.Test(t, func(t *testing.T) {
+		keyhub := keyhubtest.NewKeyHub(testRoles...)
+		client := newTestClient(t, keyhub, nil)
+		client.Config.Keyhub.SecretStore = SecretStoreKeyring
+		ctx := context.Background()
+
+		if _, err := client.DoLogin(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if secret, err := keyring.Get(keyringService, DefaultContext); err != nil || secret == "" {
+			t.Errorf("refresh token not stored in the keyring: %v", err)
+		}
+		refreshTokenPath, _ := GetAwsKeyHubRefreshTokenPath()
+		if _, err := os.Stat(refreshTokenPath); !os.IsNotExist(err) {
+			t.Error("refresh token stored in a plain file")
+		}
+		if _, err := client.DoLogin(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if refreshes := keyhub.Requests("refresh_token"); refreshes != 1 {
+			t.Errorf("used refresh token from the keyring %d times, want 1", refreshes)
+		}
+	})
+}
+
+func TestEncryptedFileSecretStore(t *testing.T) {
+	keyhubtest.SetupHome(t)
+	if err := AssureAwsKeyHubConfigDirectoryExists(); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(PassphraseEnvironmentVariable, "correct horse battery staple")
+	secretStore, err := NewSecretStore(SecretStoreEncryptedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte(`{"refresh_token":"secret-refresh-token"}`)
+
+	if err := secretStore.Store(DefaultContext, secret); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := secretStore.Load(DefaultContext); err != nil || !bytes.Equal(loaded, secret) {
+		t.Errorf("loaded %q, %v, want the stored secret", loaded, err)
+	}
+	path, _ := getAwsKeyHubEncryptedRefreshTokenPathForContext(DefaultContext)
+	if encrypted, err := os.ReadFile(path); err != nil || bytes.Contains(encrypted, []byte("secret-refresh-token")) {
+		t.Errorf("refresh token not encrypted in %s: %v", path, err)
+	}
+
+	t.Setenv(PassphraseEnvironmentVariable, "wrong")
+	otherSecretStore, _ := NewSecretStore(SecretStoreEncryptedFile)
+	if _, err := otherSecretStore.Load(DefaultContext); err == nil {
+		t.Error("decrypted the refresh token with the wrong passphrase")
+	}
+
+	if err := secretStore.Delete(DefaultContext); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := secretStore.Load(DefaultContext); loaded != nil || err != nil {
+		t.Errorf("loaded %q, %v after delete, want nothing", loaded, err)
+	}
+}
+
+func TestMigrateRefreshTokenFile(t *testing.T) {
+	keyring.MockInit()
+	keyhub := keyhubtest.NewKeyHub(testRoles...)
+	client := newTestClient(t, keyhub, nil)
+	secret := []byte(`{"refresh_token":"migrated-refresh-token"}`)
+	if err := (fileSecretStore{}).Store(DefaultContext, secret); err != nil {
+		t.Fatal(err)
+	}
+	client.Config.Keyhub.SecretStore = SecretStoreKeyring
+
+	refreshTokenFile, err := client.readRefreshToken()
+	if err != nil || refreshTokenFile == nil || refreshTokenFile.RefreshToken != "migrated-refresh-token" {
+		t.Fatalf("read %v, %v, want the refresh token of the file", refreshTokenFile, err)
+	}
+	if stored, _ := keyring.Get(keyringService, DefaultContext); stored != string(secret) {
+		t.Errorf("keyring contains %q, want the migrated refresh token", stored)
+	}
+	if plain, _ := (fileSecretStore{}).Load(DefaultContext); plain != nil {
+		t.Error("refresh token file not removed after migration")
+	}
+}
+
+func TestEncryptedCredentialCache(t *testing.T) {
+	keyring.MockInit()
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	client.Config.Keyhub.SecretStore = SecretStoreKeyring
+	client.Config.Aws.EncryptCredentials = true
+	expiration := time.Now().Add(time.Hour)
+	credentials := &types.Credentials{
+		AccessKeyId:     aws.String("ASIAENCRYPTED"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      &expiration,
+	}
+	role := RolesAndPrincipals{Role: testRoles[0].RoleArn, Principal: testRoles[0].PrincipalArn}
+
+	if err := client.StoreCachedCredentials(role, "keyhub", credentials); err != nil {
+		t.Fatal(err)
+	}
+	cachedCredentials, err := client.GetCachedCredentialsForRole(role.Role)
+	if err != nil || cachedCredentials == nil || *cachedCredentials.Credentials.AccessKeyId != "ASIAENCRYPTED" {
+		t.Fatalf("got %v, %v, want the stored credentials", cachedCredentials, err)
+	}
+	path, _ := getAwsKeyHubEncryptedCredentialCachePathForContext(DefaultContext)
+	if encrypted, err := os.ReadFile(path); err != nil || bytes.Contains(encrypted, []byte("ASIAENCRYPTED")) {
+		t.Errorf("credentials not encrypted in %s: %v", path, err)
+	}
+
+	client.Config.Keyhub.SecretStore = SecretStoreFile
+	client.secretStore = nil
+	if err := client.StoreCachedCredentials(role, "keyhub", credentials); err == nil {
+		t.Error("stored encrypted credentials with the plain file secret store")
+	}
+}
+
+func TestEncryptedCredentialCacheWrongPassphrase(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	client.Config.Keyhub.SecretStore = SecretStoreEncryptedFile
+	client.Config.Aws.EncryptCredentials = true
+	t.Setenv(PassphraseEnvironmentVariable, "correct horse battery staple")
+	expiration := time.Now().Add(time.Hour)
+	credentials := &types.Credentials{AccessKeyId: aws.String("ASIAENCRYPTED"), SecretAccessKey: aws.String("secret"), SessionToken: aws.String("token"), Expiration: &expiration}
+	role := RolesAndPrincipals{Role: testRoles[0].RoleArn, Principal: testRoles[0].PrincipalArn}
+	if err := client.StoreCachedCredentials(role, "keyhub", credentials); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := getAwsKeyHubEncryptedCredentialCachePathForContext(DefaultContext)
+	encrypted, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(PassphraseEnvironmentVariable, "wrong passphrase")
+	client.secretStore = nil
+	if _, err := client.GetTrackedProfiles(); err == nil {
+		t.Error("read the credential cache with the wrong passphrase")
+	}
+	if err := client.StoreCachedCredentials(role, "keyhub", credentials); err == nil {
+		t.Error("stored credentials with the wrong passphrase")
+	}
+	if stored, _ := os.ReadFile(path); !bytes.Equal(stored, encrypted) {
+		t.Error("credential cache overwritten with the wrong passphrase")
+	}
+
+	// Damaged data cannot be recovered with another passphrase, it is ignored.
+	if err := os.WriteFile(path, []byte("not encrypted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if trackedProfiles, err := client.GetTrackedProfiles(); err != nil || len(trackedProfiles) != 0 {
+		t.Errorf("got %v, %v for a corrupt credential cache, want an empty cache", trackedProfiles, err)
+	}
+}
@@ -0,0 +1,128 @@
+package aws_keyhub
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+const (
+	// ProfileStateValid is a profile with a session that is valid for longer than the cache margin.
+	ProfileStateValid = "valid"
+	// ProfileStateExpiring is a profile with a session that expires within the cache margin.
+	ProfileStateExpiring = "expiring"
+	// ProfileStateExpired is a profile with an expired session.
+	ProfileStateExpired = "expired"
+	// ProfileStateChanged is a profile that was changed in the credentials file outside of aws-keyhub.
+	ProfileStateChanged = "changed"
+)
+
+// Status describes what aws-keyhub stored for the current context.
+type Status struct {
+	Context      string             `json:"context"`
+	RefreshToken RefreshTokenStatus `json:"refreshToken"`
+	Profiles     []ProfileStatus    `json:"profiles"`
+}
+
+type RefreshTokenStatus struct {
+	SecretStore string     `json:"secretStore"`
+	Stored      bool       `json:"stored"`
+	Valid       bool       `json:"valid"`
+	ExpireDate  *time.Time `json:"expireDate,omitempty"`
+	// Error is why the refresh token could not be read, for example a missing passphrase.
+	Error string `json:"error,omitempty"`
+}
+
+// ProfileStatus is a profile written by login, with the session of the role in it.
+type ProfileStatus struct {
+	Profile     string    `json:"profile"`
+	RoleArn     string    `json:"roleArn"`
+	Description string    `json:"description,omitempty"`
+	Expiration  time.Time `json:"expiration"`
+	State       string    `json:"state"`
+	// CallerArn is the identity of the session according to STS GetCallerIdentity, when verified.
+	CallerArn string `json:"callerArn,omitempty"`
+	// VerifyError is the error of STS GetCallerIdentity, when verified.
+	VerifyError string `json:"verifyError,omitempty"`
+}
+
+// Status returns the state of the refresh token and the profiles written by login. When verify is set, the sessions
+// that did not expire are verified with STS GetCallerIdentity. Status does not change the stored refresh token and
+// reports when it cannot be read, instead of failing.
+func (client *Client) Status(ctx context.Context, verify bool) (*Status, error) {
+	status := &Status{
+		Context:      client.contextName,
+		RefreshToken: RefreshTokenStatus{SecretStore: client.Config.Keyhub.SecretStore},
+		Profiles:     []ProfileStatus{},
+	}
+	if status.RefreshToken.SecretStore == "" {
+		status.RefreshToken.SecretStore = SecretStoreFile
+	}
+	refreshTokenFile, err := client.peekRefreshToken()
+	if err != nil {
+		status.RefreshToken.Error = err.Error()
+	} else if refreshTokenFile != nil {
+		status.RefreshToken.Stored = true
+		status.RefreshToken.Valid = isAccessTokenValid(*refreshTokenFile)
+		status.RefreshToken.ExpireDate = &refreshTokenFile.ExpireDate
+	}
+
+	trackedProfiles, err := client.GetTrackedProfiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, tracked := range trackedProfiles {
+		profileStatus := ProfileStatus{
+			Profile:     tracked.Profile,
+			RoleArn:     tracked.RoleArn,
+			Description: tracked.Description,
+			Expiration:  *tracked.Credentials.Expiration,
+		}
+		switch {
+		case !profileStatus.Expiration.After(time.Now()):
+			profileStatus.State = ProfileStateExpired
+		case !client.IsCachedCredentialValid(tracked):
+			profileStatus.State = ProfileStateExpiring
+		default:
+			profileStatus.State = ProfileStateValid
+		}
+		// Encrypted credentials are never written to the credentials file.
+		if !client.Config.Aws.EncryptCredentials {
+			accessKeyId, err := ReadCredentialFileAccessKeyId(tracked.Profile)
+			if err != nil {
+				return nil, err
+			}
+			if accessKeyId != *tracked.Credentials.AccessKeyId {
+				profileStatus.State = ProfileStateChanged
+			}
+		}
+		if verify && (profileStatus.State == ProfileStateValid || profileStatus.State == ProfileStateExpiring) {
+			callerIdentity, err := client.GetCallerIdentity(ctx, tracked.Credentials)
+			if err != nil {
+				profileStatus.VerifyError = err.Error()
+			} else {
+				profileStatus.CallerArn = *callerIdentity.Arn
+			}
+		}
+		status.Profiles = append(status.Profiles, profileStatus)
+	}
+	return status, nil
+}
+
+// GetCallerIdentity calls STS GetCallerIdentity with the session credentials.
+func (client *Client) GetCallerIdentity(ctx context.Context, sessionCredentials types.Credentials) (*sts.GetCallerIdentityOutput, error) {
+	svc, err := client.getStsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := svc.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{}, func(options *sts.Options) {
+		options.Credentials = credentials.NewStaticCredentialsProvider(*sessionCredentials.AccessKeyId, *sessionCredentials.SecretAccessKey, *sessionCredentials.SessionToken)
+	})
+	if err != nil {
+		return nil, &StsError{Operation: "GetCallerIdentity", Err: err}
+	}
+	return result, nil
+}
@@ -0,0 +1,115 @@
+package aws_keyhub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/topicuskeyhub/aws-keyhub/internal/keyhubtest"
+	"github.com/zalando/go-keyring"
+)
+
+func TestStatusProfileStates(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	session := func(accessKeyId string, validFor time.Duration) *types.Credentials {
+		expiration := time.Now().Add(validFor)
+		return &types.Credentials{AccessKeyId: aws.String(accessKeyId), SecretAccessKey: aws.String("secret"), SessionToken: aws.String("token"), Expiration: &expiration}
+	}
+	for _, profile := range []struct {
+		name       string
+		roleArn    string
+		credential *types.Credentials
+		written    *types.Credentials
+	}{
+		{"valid", "arn:aws:iam::123456789012:role/Valid", session("ASIAVALID", time.Hour), nil},
+		{"expiring", "arn:aws:iam::123456789012:role/Expiring", session("ASIAEXPIRING", time.Minute), nil},
+		{"expired", "arn:aws:iam::123456789012:role/Expired", session("ASIAEXPIRED", -time.Minute), nil},
+		{"changed", "arn:aws:iam::123456789012:role/Changed", session("ASIACHANGED", time.Hour), session("AKIAOTHER", time.Hour)},
+	} {
+		written := profile.written
+		if written == nil {
+			written = profile.credential
+		}
+		if err := WriteCredentialFile(profile.name, written); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.StoreCachedCredentials(RolesAndPrincipals{Role: profile.roleArn}, profile.name, profile.credential); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := client.Status(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RefreshToken.Stored {
+		t.Errorf("refresh token status %+v without login", status.RefreshToken)
+	}
+	states := make(map[string]string)
+	for _, profile := range status.Profiles {
+		states[profile.Profile] = profile.State
+	}
+	for profile, expected := range map[string]string{"valid": ProfileStateValid, "expiring": ProfileStateExpiring, "expired": ProfileStateExpired, "changed": ProfileStateChanged} {
+		if states[profile] != expected {
+			t.Errorf("profile %s has state %q, want %q", profile, states[profile], expected)
+		}
+	}
+}
+
+func TestStatusDoesNotMigrateRefreshToken(t *testing.T) {
+	keyring.MockInit()
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	if err := (fileSecretStore{}).Store(DefaultContext, []byte(`{"refresh_token":"plain-refresh-token"}`)); err != nil {
+		t.Fatal(err)
+	}
+	client.Config.Keyhub.SecretStore = SecretStoreKeyring
+
+	status, err := client.Status(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.RefreshToken.Stored || status.RefreshToken.Error != "" {
+		t.Errorf("refresh token status %+v, want the refresh token of the file", status.RefreshToken)
+	}
+	if plain, _ := (fileSecretStore{}).Load(DefaultContext); plain == nil {
+		t.Error("refresh token file moved by status")
+	}
+	if stored, _ := keyring.Get(keyringService, DefaultContext); stored != "" {
+		t.Errorf("keyring contains %q after status", stored)
+	}
+}
+
+func TestStatusReportsUnreadableRefreshToken(t *testing.T) {
+	client := newTestClient(t, keyhubtest.NewKeyHub(), nil)
+	t.Setenv(PassphraseEnvironmentVariable, "correct horse battery staple")
+	encryptedFile, err := NewSecretStore(SecretStoreEncryptedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encryptedFile.Store(DefaultContext, []byte(`{"refresh_token":"secret-refresh-token"}`)); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(PassphraseEnvironmentVariable, "")
+	client.Config.Keyhub.SecretStore = SecretStoreEncryptedFile
+	expiration := time.Now().Add(time.Hour)
+	credentials := &types.Credentials{AccessKeyId: aws.String("ASIAVALID"), SecretAccessKey: aws.String("secret"), SessionToken: aws.String("token"), Expiration: &expiration}
+	if err := WriteCredentialFile("valid", credentials); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StoreCachedCredentials(RolesAndPrincipals{Role: "arn:aws:iam::123456789012:role/Valid"}, "valid", credentials); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := client.Status(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RefreshToken.Error == "" || status.RefreshToken.Stored {
+		t.Errorf("refresh token status %+v, want the missing passphrase", status.RefreshToken)
+	}
+	if len(status.Profiles) != 1 || status.Profiles[0].Profile != "valid" {
+		t.Errorf("profiles %+v, want the profile of the cached session", status.Profiles)
+	}
+}
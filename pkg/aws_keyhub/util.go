@@ -1,14 +1,14 @@
 package aws_keyhub
 
 import (
-	"github.com/sirupsen/logrus"
+	"fmt"
 	"os"
 )
 
-func getUserHomeDir() string {
+func getUserHomeDir() (string, error) {
 	userHomeDir, err := os.UserHomeDir()
 	if err != nil {
-		logrus.Fatal(err)
+		return "", fmt.Errorf("unable to determine home directory: %w", err)
 	}
-	return userHomeDir
+	return userHomeDir, nil
 }